@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	_ "embed"
 	"errors"
@@ -9,12 +9,17 @@ import (
 	"html/template"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/2bitburrito/http-implementation/internal/byterange"
+	"github.com/2bitburrito/http-implementation/internal/cache"
+	"github.com/2bitburrito/http-implementation/internal/client"
+	"github.com/2bitburrito/http-implementation/internal/compress"
 	"github.com/2bitburrito/http-implementation/internal/headers"
 	"github.com/2bitburrito/http-implementation/internal/request"
 	"github.com/2bitburrito/http-implementation/internal/response"
@@ -24,11 +29,12 @@ import (
 const port = 42069
 
 func main() {
-	server, err := server.Serve(port, Handler)
+	server, err := server.Serve(port, compress.Middleware(compress.DefaultMinSize, Handler))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
+	defer upstreamCache.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
@@ -46,8 +52,15 @@ type rtnMsg struct {
 //go:embed static/template.html
 var htmlTemplate string
 
-func Handler(w *response.Writer, r *request.Request) {
-	pStr := strings.Trim(r.RequestLine.RequestTarget, "/")
+func Handler(w response.Writer, r *request.Request) {
+	// Nothing here can open a tunnel, and any 2xx would tell the client
+	// that one was open (RFC 9110 section 9.3.6)
+	if r.RequestLine.Method == "CONNECT" {
+		w.WriteStatusLine(response.NotImplemented)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return
+	}
+	pStr := strings.Trim(r.Target.Path, "/")
 	paths := strings.Split(pStr, "/")
 	switch paths[0] {
 	case "yourproblem":
@@ -67,129 +80,232 @@ func Handler(w *response.Writer, r *request.Request) {
 	}
 }
 
-func handle400(w *response.Writer, _ *request.Request) {
+func handle400(w response.Writer, _ *request.Request) {
 	msg := rtnMsg{}
 	tmpl := template.New("template")
 	tmpl.Parse(htmlTemplate)
-	buf := bytes.Buffer{}
 	status := "Bad Request"
 	msg.Title = fmt.Sprintf("%d %s", 400, status)
 	msg.Status = status
 	msg.Message = "Your request honestly kinda sucked."
-	if err := tmpl.Execute(&buf, msg); err != nil {
-		fmt.Printf("Bad template execution")
-		return
-	}
 	w.WriteStatusLine(400)
-	h := response.GetDefaultHeaders(buf.Len())
-	h["Content-Type"] = "text/html"
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/html")
 	w.WriteHeaders(h)
-	w.WriteBody(buf.Bytes())
+	if err := tmpl.Execute(response.BodyWriter(w), msg); err != nil {
+		fmt.Printf("Bad template execution")
+	}
 }
 
-func handle500(w *response.Writer, _ *request.Request) {
+func handle500(w response.Writer, _ *request.Request) {
 	msg := rtnMsg{}
 	tmpl := template.New("template")
 	tmpl.Parse(htmlTemplate)
-	buf := bytes.Buffer{}
 	status := "Internal Server Error"
 	msg.Title = fmt.Sprintf("%d %s", 500, status)
 	msg.Status = status
 	msg.Message = "Okay, you know what? This one is on me."
-	if err := tmpl.Execute(&buf, msg); err != nil {
-		fmt.Printf("Bad template execution")
-		return
-	}
 	w.WriteStatusLine(500)
-	h := response.GetDefaultHeaders(buf.Len())
-	h["Content-Type"] = "text/html"
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/html")
 	w.WriteHeaders(h)
-	w.WriteBody(buf.Bytes())
+	if err := tmpl.Execute(response.BodyWriter(w), msg); err != nil {
+		fmt.Printf("Bad template execution")
+	}
 }
 
-func handleHTTPBin(w *response.Writer, _ *request.Request, paths []string) {
-	url := fmt.Sprintf("https://httpbin.org/%s", paths[1])
+// upstreamCache holds responses proxied from httpbin
+var upstreamCache = cache.New(32<<20, fetchUpstream)
+
+// proxiedHeaders are passed through from the upstream response
+var proxiedHeaders = []string{"content-type", "cache-control", "etag", "last-modified", "expires", "vary", "age"}
+
+func handleHTTPBin(w response.Writer, r *request.Request, paths []string) {
+	if len(paths) < 2 || paths[1] == "" {
+		w.WriteStatusLine(response.NotFound)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return
+	}
+	// The path is escaped again so that anything decoded from it stays part
+	// of the upstream path, while the query is passed on as it was sent
+	escaped := strings.Split(strings.Trim(r.Target.EscapedPath(), "/"), "/")
+	url := fmt.Sprintf("https://httpbin.org/%s", escaped[1])
+	if r.Target.RawQuery != "" {
+		url += "?" + r.Target.RawQuery
+	}
 	fmt.Println("URL", url)
-	bResp, err := http.Get(url)
+	bResp, err := upstreamCache.Get(url, r.Headers)
 	if err != nil {
 		fmt.Println("bad request to: ", url)
 		w.WriteStatusLine(500)
 		return
 	}
-	defer bResp.Body.Close()
-	w.WriteStatusLine(200)
+	status := response.StatusCode(bResp.StatusCode)
+	w.WriteStatusLine(status)
 
-	h := map[string]string{
-		"connection":        "close",
-		"Transfer-Encoding": "chunked",
-		"Trailer":           "X-Content-SHA256, X-Content-Length",
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	for _, k := range proxiedHeaders {
+		for _, v := range bResp.Headers.Values(k) {
+			h.Add(k, v)
+		}
+	}
+	if !response.BodyAllowed(status) {
+		w.WriteHeaders(h)
+		return
 	}
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
 	w.WriteHeaders(h)
 
-	totalB := make([]byte, 0, 1024)
-	b := make([]byte, 1024)
-	for {
-		n, err := bResp.Body.Read(b)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			fmt.Println("error while reading body :", err)
-			return
-		}
-		w.WriteChunkedBody(b[:n])
-		totalB = append(totalB, b[:n]...)
+	for chunk := range slices.Chunk(bResp.Body, 1024) {
+		w.WriteChunkedBody(chunk)
 	}
 	w.WriteChunkedBodyDone()
 
 	sha := sha256.New()
-	sha.Write(totalB)
+	sha.Write(bResp.Body)
 	sum := sha.Sum(nil)
 
-	trailers := headers.Headers{
-		"X-Content-Length": fmt.Sprintf("%d", len(totalB)),
-		"X-Content-SHA256": fmt.Sprintf("%x", sum),
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(bResp.Body)))
+	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sum))
+	if tw, ok := w.(response.TrailerWriter); ok {
+		tw.WriteTrailers(trailers)
 	}
-	w.WriteTrailers(trailers)
 }
 
-func serveVideo(w *response.Writer, _ *request.Request) {
-	file, err := os.ReadFile("./assets/vim.mp4")
+// upstreamRequestHeaders are forwarded to httpbin, as they can affect what
+// it responds with or whether it can be cached. The cache strips a client's
+// own validators before filling, so the conditional fields that get here are
+// the cache's own when revalidating.
+var upstreamRequestHeaders = []string{"accept", "accept-language", "authorization", "cache-control", "if-none-match", "if-modified-since"}
+
+// upstreamClient fetches from httpbin, giving up on it after a while
+var upstreamClient = &client.Client{Timeout: 30 * time.Second}
+
+func fetchUpstream(ctx context.Context, url string, reqHeaders *headers.Headers) (*cache.Response, error) {
+	req, err := request.NewRequest("GET", url, nil)
 	if err != nil {
-		fmt.Println("couldn't open file: ", err)
-		return
+		return nil, err
+	}
+	for _, k := range upstreamRequestHeaders {
+		if v, ok := reqHeaders.Get(k); ok {
+			req.Headers.Set(k, v)
+		}
 	}
-	h := headers.Headers{
-		"connection":     "close",
-		"Content-Type":   "video/mp4",
-		"Content-Length": fmt.Sprintf("%d", len(file)),
+	bResp, err := upstreamClient.Do(ctx, req)
+	if err != nil {
+		return nil, err
 	}
-	w.WriteStatusLine(200)
-	if err := w.WriteHeaders(h); err != nil {
-		fmt.Println("bad writing to headers", err)
+	defer bResp.Body.Close()
+	body, err := io.ReadAll(bResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading body: %w", err)
+	}
+	return &cache.Response{
+		StatusCode: int(bResp.StatusCode),
+		Headers:    bResp.Headers,
+		Body:       body,
+	}, nil
+}
+
+func serveVideo(w response.Writer, r *request.Request) {
+	file, err := os.Open("./assets/vim.mp4")
+	if err != nil {
+		fmt.Println("couldn't open file: ", err)
 		return
 	}
-	if _, err := w.WriteBody(file); err != nil {
-		fmt.Println("couldn't write body: ", err)
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Println("couldn't stat file: ", err)
 		return
 	}
+	size := info.Size()
+	etag := fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), size)
+
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", info.ModTime().UTC().Format(headers.TimeFormat))
+
+	var ranges []byterange.Range
+	rangeHdr, hasRange := r.Headers.Get("Range")
+	if ifRange, ok := r.Headers.Get("If-Range"); ok && !byterange.IfRangeMatches(ifRange, etag, info.ModTime()) {
+		hasRange = false
+	}
+	if hasRange {
+		ranges, err = byterange.Parse(rangeHdr, size)
+		if errors.Is(err, byterange.ErrUnsatisfiable) {
+			h.Set("Content-Range", byterange.UnsatisfiedRange(size))
+			h.Set("Content-Length", "0")
+			w.WriteStatusLine(response.RangeNotSatisfiable)
+			w.WriteHeaders(h)
+			return
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Type", "video/mp4")
+		h.Set("Content-Length", fmt.Sprintf("%d", size))
+		w.WriteStatusLine(response.OK)
+		if err := w.WriteHeaders(h); err != nil {
+			fmt.Println("bad writing to headers", err)
+			return
+		}
+		if _, err := response.Copy(w, file); err != nil {
+			fmt.Println("couldn't write body: ", err)
+			return
+		}
+	case 1:
+		rng := ranges[0]
+		h.Set("Content-Type", "video/mp4")
+		h.Set("Content-Range", rng.ContentRange(size))
+		h.Set("Content-Length", fmt.Sprintf("%d", rng.Length))
+		w.WriteStatusLine(response.PartialContent)
+		if err := w.WriteHeaders(h); err != nil {
+			fmt.Println("bad writing to headers", err)
+			return
+		}
+		section := io.NewSectionReader(file, rng.Start, rng.Length)
+		if _, err := response.Copy(w, section); err != nil {
+			fmt.Println("couldn't write body: ", err)
+			return
+		}
+	default:
+		mp := byterange.NewMultipart("video/mp4", size, ranges)
+		h.Set("Content-Type", mp.MediaType())
+		h.Set("Content-Length", fmt.Sprintf("%d", mp.Len()))
+		w.WriteStatusLine(response.PartialContent)
+		if err := w.WriteHeaders(h); err != nil {
+			fmt.Println("bad writing to headers", err)
+			return
+		}
+		if _, err := mp.Copy(response.BodyWriter(w), file); err != nil {
+			fmt.Println("couldn't write body: ", err)
+			return
+		}
+	}
 }
 
-func handleDefault(w *response.Writer, _ *request.Request) {
+func handleDefault(w response.Writer, _ *request.Request) {
 	msg := rtnMsg{}
 	tmpl := template.New("template")
 	tmpl.Parse(htmlTemplate)
-	buf := bytes.Buffer{}
 	msg.Title = fmt.Sprintf("%d OK", 200)
 	msg.Status = "Success!"
 	msg.Message = "Your request was an absolute banger."
-	if err := tmpl.Execute(&buf, msg); err != nil {
-		fmt.Printf("Bad template execution")
-		return
-	}
 	w.WriteStatusLine(200)
-	h := response.GetDefaultHeaders(buf.Len())
-	h["Content-Type"] = "text/html"
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	h.Set("Content-Type", "text/html")
 	w.WriteHeaders(h)
-	w.WriteBody(buf.Bytes())
+	if err := tmpl.Execute(response.BodyWriter(w), msg); err != nil {
+		fmt.Printf("Bad template execution")
+	}
 }
@@ -1,33 +1,96 @@
 package main
 
 import (
-	"bytes"
-	"io"
-	"net/http"
+	"context"
+	"strings"
 	"testing"
 
+	"github.com/2bitburrito/http-implementation/internal/cache"
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/httptest"
+	"github.com/2bitburrito/http-implementation/internal/response"
 	"github.com/2bitburrito/http-implementation/internal/server"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestMain(t *testing.T) {
-	server, err := server.Serve(45288, Handler)
-	require.NoError(t, err, "error starting server")
-	require.NotNil(t, server)
+	s := &server.Server{Handler: Handler}
 
-	defer server.Close()
-
-	resp, err := http.Get("http://localhost:45288" + "/ping")
+	resp, err := httptest.RoundTrip(s, "GET /ping HTTP/1.1\r\nHost: localhost\r\n\r\n")
 	require.NoError(t, err, "couldn't request server")
-	require.NotNil(t, resp)
-	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "Your request was an absolute banger.")
+}
+
+func TestHandler(t *testing.T) {
+	// Test: Error pages use their status
+	rec := httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET /yourproblem HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, response.BadRequest, rec.Code)
+	contentType, _ := rec.Headers.Get("Content-Type")
+	assert.Equal(t, "text/html", contentType)
+	assert.Contains(t, rec.Body.String(), "Your request honestly kinda sucked.")
+
+	rec = httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET /myproblem HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, response.InternalServerError, rec.Code)
+
+	// Test: CONNECT isn't answered as though a tunnel were open
+	rec = httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("CONNECT example.com:443 HTTP/1.1\nHost: example.com:443\n"))
+	assert.Equal(t, response.NotImplemented, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestHandleHTTPBin(t *testing.T) {
+	upstream := upstreamCache
+	defer func() { upstreamCache = upstream }()
+	var fetched string
+	upstreamCache = cache.New(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*cache.Response, error) {
+		fetched = url
+		status := 200
+		switch url {
+		case "https://httpbin.org/missing":
+			status = 404
+		case "https://httpbin.org/unchanged":
+			status = 304
+		}
+		return &cache.Response{StatusCode: status, Headers: headers.NewHeaders(), Body: []byte("upstream")}, nil
+	})
+
+	// Test: Upstream body is proxied with trailers
+	rec := httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET /httpbin/get HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, response.OK, rec.Code)
+	assert.Equal(t, "upstream", rec.Body.String())
+	assert.True(t, rec.Trailers.Has("X-Content-SHA256"))
+
+	// Test: Upstream status is passed through
+	rec = httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET /httpbin/missing HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, response.NotFound, rec.Code)
+
+	// Test: No body or trailers for a status without a body
+	rec = httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest("GET /httpbin/unchanged HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, response.NotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.False(t, rec.Headers.Has("Transfer-Encoding"))
+	assert.Nil(t, rec.Trailers)
+
+	// Test: Query is forwarded
+	Handler(httptest.NewRecorder(), httptest.NewRequest("GET /httpbin/anything?x=1 HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, "https://httpbin.org/anything?x=1", fetched)
 
-	defer resp.Body.Close()
+	// Test: Encoded delimiters stay in the upstream path
+	Handler(httptest.NewRecorder(), httptest.NewRequest("GET /httpbin/anything%3Fx=1%23 HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, "https://httpbin.org/anything%3Fx=1%23", fetched)
 
-	data, err := io.ReadAll(resp.Body)
-	require.NoError(t, err, "error reading body")
-	require.NotNil(t, data)
-	success := bytes.Contains(data, []byte("Your request was an absolute banger."))
-	assert.True(t, success)
+	// Test: Nothing to proxy
+	for _, target := range []string{"/httpbin", "/httpbin/"} {
+		rec = httptest.NewRecorder()
+		Handler(rec, httptest.NewRequest("GET "+target+" HTTP/1.1\nHost: localhost\n"))
+		assert.Equal(t, response.NotFound, rec.Code, target)
+	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 
@@ -30,10 +31,14 @@ func main() {
 			r.RequestLine.RequestTarget,
 			r.RequestLine.HTTPVersion)
 		fmt.Printf("Headers:\n")
-		for key, value := range r.Headers {
+		for key, value := range r.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
-		fmt.Printf("Body:\n%s\n", string(r.Body))
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Fatal("error reading body: ", err)
+		}
+		fmt.Printf("Body:\n%s\n", string(body))
 		fmt.Printf("Connection Closed From: %s\n", conn.RemoteAddr())
 	}
 }
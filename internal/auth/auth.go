@@ -0,0 +1,286 @@
+// Package auth implements Basic (RFC 7617) and Digest (RFC 7616)
+// authentication for server handlers
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/2bitburrito/http-implementation/internal/server"
+)
+
+// DefaultNonceTTL is how long a Digest nonce stays valid
+const DefaultNonceTTL = 5 * time.Minute
+
+// DefaultMaxPasswordChecks bounds the Basic passwords hashed at once, each of
+// which takes the memory cost of its hash
+const DefaultMaxPasswordChecks = 4
+
+type Authenticator struct {
+	Realm    string
+	Users    *UserFile
+	Basic    bool
+	Digest   bool
+	NonceTTL time.Duration
+	// MaxPasswordChecks bounds the Basic passwords hashed at once. Requests
+	// past it get a 503 rather than waiting. Zero uses the default.
+	MaxPasswordChecks int
+
+	secret []byte
+	opaque string
+	now    func() time.Time
+
+	mu        sync.Mutex
+	nonceUses map[string]uint64
+	checking  int
+}
+
+// New creates an Authenticator accepting both Basic and Digest credentials
+func New(realm string, users *UserFile) *Authenticator {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	opaque := make([]byte, 16)
+	rand.Read(opaque)
+	return &Authenticator{
+		Realm:     realm,
+		Users:     users,
+		Basic:     true,
+		Digest:    true,
+		NonceTTL:  DefaultNonceTTL,
+		secret:    secret,
+		opaque:    hex.EncodeToString(opaque),
+		now:       time.Now,
+		nonceUses: map[string]uint64{},
+	}
+}
+
+// Middleware only passes authenticated requests on to next, with
+// req.Principal set to the username. Everything else gets a 401.
+func (a *Authenticator) Middleware(next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) {
+		principal, stale, busy := a.authenticate(req)
+		if busy {
+			h := response.GetDefaultHeaders(0)
+			h.Set("Retry-After", "1")
+			w.WriteStatusLine(response.ServiceUnavailable)
+			w.WriteHeaders(h)
+			return
+		}
+		if principal == "" {
+			a.challenge(w, stale)
+			return
+		}
+		req.Principal = principal
+		next(w, req)
+	}
+}
+
+// authenticate returns the authenticated username, or "" if the request
+// carries no valid credentials. stale reports a Digest response that was
+// correct apart from using an expired nonce, and busy a Basic password that
+// couldn't be checked as too many already are.
+func (a *Authenticator) authenticate(req *request.Request) (principal string, stale, busy bool) {
+	authorization, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", false, false
+	}
+	scheme, params, _ := strings.Cut(strings.TrimSpace(authorization), " ")
+	switch {
+	case a.Basic && strings.EqualFold(scheme, "Basic"):
+		principal, busy = a.checkBasic(strings.TrimSpace(params))
+		return principal, false, busy
+	case a.Digest && strings.EqualFold(scheme, "Digest"):
+		principal, stale = a.checkDigest(req, params)
+		return principal, stale, false
+	default:
+		return "", false, false
+	}
+}
+
+func (a *Authenticator) checkBasic(token string) (string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", false
+	}
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", false
+	}
+	if !a.startCheck() {
+		return "", true
+	}
+	defer a.endCheck()
+	// Unknown users are checked against a dummy so they take as long
+	cred, ok := a.Users.lookup(username)
+	if !cred.checkPassword(password) || !ok {
+		return "", false
+	}
+	return username, false
+}
+
+// startCheck takes one of the MaxPasswordChecks slots, reporting false if
+// they are all in use
+func (a *Authenticator) startCheck() bool {
+	limit := a.MaxPasswordChecks
+	if limit <= 0 {
+		limit = DefaultMaxPasswordChecks
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.checking >= limit {
+		return false
+	}
+	a.checking++
+	return true
+}
+
+func (a *Authenticator) endCheck() {
+	a.mu.Lock()
+	a.checking--
+	a.mu.Unlock()
+}
+
+func (a *Authenticator) checkDigest(req *request.Request, header string) (string, bool) {
+	params := parseAuthParams(header)
+	username := params["username"]
+	if username == "" ||
+		params["realm"] != a.Realm ||
+		params["qop"] != "auth" ||
+		params["opaque"] != a.opaque ||
+		!strings.EqualFold(params["algorithm"], "SHA-256") ||
+		params["uri"] != req.RequestLine.RequestTarget {
+		return "", false
+	}
+	cred, ok := a.Users.lookup(username)
+	if !ok || cred.ha1 == "" {
+		return "", false
+	}
+
+	nonce := params["nonce"]
+	issued, ok := a.verifyNonce(nonce)
+	if !ok {
+		return "", false
+	}
+	ha2 := sha256Hex(req.RequestLine.Method + ":" + params["uri"])
+	expected := sha256Hex(strings.Join([]string{cred.ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 {
+		return "", false
+	}
+	if a.now().Sub(issued) > a.NonceTTL {
+		return "", true
+	}
+	if !a.useNonce(nonce, params["nc"]) {
+		return "", false
+	}
+	return username, false
+}
+
+// useNonce rejects replays by requiring the nonce count to keep increasing
+func (a *Authenticator) useNonce(nonce, nc string) bool {
+	count, err := strconv.ParseUint(nc, 16, 64)
+	if err != nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if count <= a.nonceUses[nonce] {
+		return false
+	}
+	a.nonceUses[nonce] = count
+
+	// Forget nonces that can no longer be used
+	for n := range a.nonceUses {
+		if issued, ok := a.verifyNonce(n); !ok || a.now().Sub(issued) > a.NonceTTL {
+			delete(a.nonceUses, n)
+		}
+	}
+	return true
+}
+
+// newNonce makes a stateless nonce of the issue time signed with the secret
+func (a *Authenticator) newNonce() string {
+	b := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(a.now().UnixNano()))
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(b))
+}
+
+func (a *Authenticator) verifyNonce(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+sha256.Size {
+		return time.Time{}, false
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(b[:8])
+	if !hmac.Equal(mac.Sum(nil), b[8:]) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), true
+}
+
+func (a *Authenticator) challenge(w response.Writer, stale bool) {
+	challenges := []string{}
+	if a.Digest {
+		c := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=SHA-256, nonce=%q, opaque=%q`,
+			a.Realm, a.newNonce(), a.opaque)
+		if stale {
+			c += ", stale=true"
+		}
+		challenges = append(challenges, c)
+	}
+	if a.Basic {
+		challenges = append(challenges, fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, a.Realm))
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Set("WWW-Authenticate", strings.Join(challenges, ", "))
+	w.WriteStatusLine(response.Unauthorized)
+	w.WriteHeaders(h)
+}
+
+// parseAuthParams parses the comma separated key=value pairs of a
+// credentials header, where values may be quoted strings
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		if strings.HasPrefix(rest, `"`) {
+			val := strings.Builder{}
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				val.WriteByte(rest[i])
+			}
+			params[key] = val.String()
+			s = rest[min(i+1, len(rest)):]
+			continue
+		}
+		val, next, _ := strings.Cut(rest, ",")
+		params[key] = strings.TrimSpace(val)
+		s = next
+	}
+}
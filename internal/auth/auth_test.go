@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/httptest"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const realm = "internal tools"
+
+// cheap keeps the tests fast where the cost of the hash doesn't matter
+var cheap = HashParams{Time: 1, Memory: 8, Threads: 1}
+
+func hash(password string) string {
+	return HashPasswordParams(password, cheap)
+}
+
+func writeUsers(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+}
+
+func newRequest(t *testing.T, method, target, authorization string) *request.Request {
+	t.Helper()
+	raw := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: localhost:42069\r\n", method, target)
+	if authorization != "" {
+		raw += "Authorization: " + authorization + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func digestResponse(a *Authenticator, username, password, method, uri, nonce, nc, cnonce string) string {
+	ha1 := DigestHA1(username, realm, password)
+	ha2 := sha256Hex(method + ":" + uri)
+	resp := sha256Hex(strings.Join([]string{ha1, nonce, nc, cnonce, "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", uri="%s", algorithm=SHA-256, nonce="%s", nc=%s, cnonce="%s", qop=auth, response="%s", opaque="%s"`,
+		username, realm, uri, nonce, nc, cnonce, resp, a.opaque)
+}
+
+func TestUserFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+
+	// Test: Comments and blank lines are skipped
+	writeUsers(t, path, "# users", "", "alice:"+hash("secret"))
+	users, err := LoadUserFile(path)
+	require.NoError(t, err)
+	cred, ok := users.lookup("alice")
+	require.True(t, ok)
+	assert.True(t, cred.checkPassword("secret"))
+	assert.False(t, cred.checkPassword("Secret"))
+
+	// Test: Salts differ between hashes
+	assert.NotEqual(t, hash("secret"), hash("secret"))
+
+	// Test: Unknown users get a dummy with the same cost
+	cred, ok = users.lookup("mallory")
+	assert.False(t, ok)
+	assert.Equal(t, cheap, cred.params)
+	assert.False(t, cred.checkPassword(""))
+
+	// Test: File changes are picked up
+	writeUsers(t, path, "bob:"+hash("hunter2"), "# changed")
+	users.checked = time.Time{}
+	_, ok = users.lookup("alice")
+	assert.False(t, ok)
+	_, ok = users.lookup("bob")
+	assert.True(t, ok)
+
+	// Test: Hashes are argon2id and verified with their own parameters
+	stored := HashPassword("secret")
+	assert.True(t, strings.HasPrefix(stored, "$argon2id$v=19$m=65536,t=3,p=4$"), stored)
+	weakened := strings.Replace(stored, "m=65536,t=3,p=4", "m=8,t=1,p=1", 1)
+	cred, err = parseHash(weakened)
+	require.NoError(t, err)
+	assert.False(t, cred.checkPassword("secret"))
+
+	// Test: Malformed file
+	writeUsers(t, path, "carol:plaintext")
+	_, err = LoadUserFile(path)
+	require.Error(t, err)
+
+	// Test: Fast salted SHA-256 hashes are no longer accepted
+	writeUsers(t, path, "carol:$sha256$00$"+strings.Repeat("00", 32))
+	_, err = LoadUserFile(path)
+	require.Error(t, err)
+	for _, bad := range []string{
+		"$argon2id$v=16$m=8,t=1,p=1$AAAA$AAAA",
+		"$argon2id$v=19$m=0,t=1,p=1$AAAA$AAAA",
+		"$argon2id$v=19$m=8,t=1,p=1$!!$AAAA",
+		"$argon2id$v=19$m=8,t=1,p=1$AAAA$",
+	} {
+		_, err = parseHash(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	writeUsers(t, path, "alice:"+hash("secret"))
+	users, err := LoadUserFile(path)
+	require.NoError(t, err)
+	a := New(realm, users)
+
+	basic := func(creds string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+	}
+
+	// Test: Good credentials
+	principal, _, _ := a.authenticate(newRequest(t, "GET", "/", basic("alice:secret")))
+	assert.Equal(t, "alice", principal)
+
+	// Test: Password containing a colon is wrong, not split
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/", basic("alice:secret:extra")))
+	assert.Equal(t, "", principal)
+
+	// Test: Unknown user
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/", basic("mallory:secret")))
+	assert.Equal(t, "", principal)
+
+	// Test: No credentials
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/", ""))
+	assert.Equal(t, "", principal)
+
+	// Test: Password checks past MaxPasswordChecks get a 503
+	a.MaxPasswordChecks = 1
+	require.True(t, a.startCheck())
+	rec := httptest.NewRecorder()
+	a.Middleware(func(w response.Writer, req *request.Request) {
+		t.Error("handler called")
+	})(rec, newRequest(t, "GET", "/", basic("alice:secret")))
+	assert.Equal(t, response.ServiceUnavailable, rec.Code)
+	retry, _ := rec.Headers.Get("Retry-After")
+	assert.Equal(t, "1", retry)
+	a.endCheck()
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/", basic("alice:secret")))
+	assert.Equal(t, "alice", principal)
+
+	// Test: Basic disabled
+	a.Basic = false
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/", basic("alice:secret")))
+	assert.Equal(t, "", principal)
+}
+
+func TestDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	writeUsers(t, path,
+		"alice:"+hash("secret")+":"+DigestHA1("alice", realm, "secret"),
+		"bob:"+hash("hunter2"))
+	users, err := LoadUserFile(path)
+	require.NoError(t, err)
+	a := New(realm, users)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	// Test: Good response
+	nonce := a.newNonce()
+	authz := digestResponse(a, "alice", "secret", "GET", "/tools", nonce, "00000001", "abc")
+	principal, stale, _ := a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "alice", principal)
+	assert.False(t, stale)
+
+	// Test: Replayed nonce count
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "", principal)
+
+	// Test: Next nonce count is fine
+	authz = digestResponse(a, "alice", "secret", "GET", "/tools", nonce, "00000002", "abc")
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "alice", principal)
+
+	// Test: Wrong password
+	authz = digestResponse(a, "alice", "wrong", "GET", "/tools", nonce, "00000003", "abc")
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "", principal)
+
+	// Test: URI must match the request target
+	authz = digestResponse(a, "alice", "secret", "GET", "/other", nonce, "00000004", "abc")
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "", principal)
+
+	// Test: Forged nonce
+	authz = digestResponse(a, "alice", "secret", "GET", "/tools", "bm90IGEgbm9uY2U", "00000001", "abc")
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "", principal)
+
+	// Test: User without a digest hash
+	authz = digestResponse(a, "bob", "hunter2", "GET", "/tools", nonce, "00000001", "abc")
+	principal, _, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "", principal)
+
+	// Test: Expired nonce is reported as stale
+	authz = digestResponse(a, "alice", "secret", "GET", "/tools", nonce, "00000005", "abc")
+	now = now.Add(DefaultNonceTTL + time.Second)
+	principal, stale, _ = a.authenticate(newRequest(t, "GET", "/tools", authz))
+	assert.Equal(t, "", principal)
+	assert.True(t, stale)
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`username="Mufasa", realm="http-auth@example.org", nc=00000001, qop=auth, response="a\"b"`)
+	assert.Equal(t, "Mufasa", params["username"])
+	assert.Equal(t, "http-auth@example.org", params["realm"])
+	assert.Equal(t, "00000001", params["nc"])
+	assert.Equal(t, "auth", params["qop"])
+	assert.Equal(t, `a"b`, params["response"])
+}
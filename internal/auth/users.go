@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// reloadInterval limits how often the credential file is checked for changes
+const reloadInterval = time.Second
+
+// argonKeyLen is the length of new password hashes
+const argonKeyLen = 32
+
+// HashParams are the argon2id cost parameters of a password hash
+type HashParams struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is in KiB
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultHashParams is the second recommended option of RFC 9106 section 4.
+// Stored hashes keep the parameters they were made with.
+var DefaultHashParams = HashParams{Time: 3, Memory: 64 << 10, Threads: 4}
+
+type credential struct {
+	salt   []byte
+	hash   []byte
+	params HashParams
+	// ha1 is SHA-256(username:realm:password), needed for Digest
+	ha1 string
+}
+
+// UserFile is a credential store backed by an htpasswd-style file with one
+// user per line:
+//
+//	username:$argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>[:<digest HA1 hex>]
+//
+// The password hash is in the PHC string format, with the salt and hash in
+// unpadded base64. Blank lines and lines starting with "#" are ignored. The
+// optional digest field is only needed for users who should be able to use
+// Digest auth, and as Digest needs a fast hash it should only be given where
+// that is worth it. The file is reloaded when it changes.
+type UserFile struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]credential
+	// dummy is checked for unknown users, costing as much as the dearest
+	// stored hash so that the time taken doesn't tell them apart
+	dummy   credential
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+func LoadUserFile(path string) (*UserFile, error) {
+	f := &UserFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *UserFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("couldn't stat user file: %w", err)
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("couldn't open user file: %w", err)
+	}
+	defer file.Close()
+
+	users := map[string]credential{}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, cred, err := parseUserLine(line)
+		if err != nil {
+			return fmt.Errorf("bad user file line %d: %w", lineNum, err)
+		}
+		users[name] = cred
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("couldn't read user file: %w", err)
+	}
+	dummy := newDummy(users)
+
+	f.mu.Lock()
+	f.users = users
+	f.dummy = dummy
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	f.checked = time.Now()
+	f.mu.Unlock()
+	return nil
+}
+
+func parseUserLine(line string) (string, credential, error) {
+	parts := strings.Split(line, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return "", credential{}, fmt.Errorf("expected username:hash[:ha1]")
+	}
+	cred, err := parseHash(parts[1])
+	if err != nil {
+		return "", credential{}, fmt.Errorf("%w for %q", err, parts[0])
+	}
+	if len(parts) == 3 {
+		cred.ha1 = strings.ToLower(parts[2])
+	}
+	return parts[0], cred, nil
+}
+
+// parseHash parses an argon2id hash in the PHC string format
+func parseHash(s string) (credential, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[1] != "argon2id" {
+		return credential{}, fmt.Errorf("unsupported hash format")
+	}
+	if fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return credential{}, fmt.Errorf("unsupported argon2 version %q", fields[2])
+	}
+	var cred credential
+	p := &cred.params
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil ||
+		p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return credential{}, fmt.Errorf("bad argon2 parameters %q", fields[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return credential{}, fmt.Errorf("bad salt: %w", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(hash) == 0 {
+		return credential{}, fmt.Errorf("bad hash")
+	}
+	cred.salt = salt
+	cred.hash = hash
+	return cred, nil
+}
+
+// newDummy makes a credential no password matches, with the largest cost
+// parameters of users, or the defaults if there are none
+func newDummy(users map[string]credential) credential {
+	dummy := credential{
+		salt: make([]byte, 16),
+		hash: make([]byte, argonKeyLen),
+	}
+	rand.Read(dummy.salt)
+	rand.Read(dummy.hash)
+	if len(users) == 0 {
+		dummy.params = DefaultHashParams
+	}
+	for _, cred := range users {
+		dummy.params.Time = max(dummy.params.Time, cred.params.Time)
+		dummy.params.Memory = max(dummy.params.Memory, cred.params.Memory)
+		dummy.params.Threads = max(dummy.params.Threads, cred.params.Threads)
+	}
+	return dummy
+}
+
+// lookup finds a user, first reloading the file if it has changed. An
+// unknown user gets the dummy credential, which no password matches.
+func (f *UserFile) lookup(name string) (credential, bool) {
+	f.mu.RLock()
+	due := time.Since(f.checked) > reloadInterval
+	f.mu.RUnlock()
+	if due {
+		f.reloadIfChanged()
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	cred, ok := f.users[name]
+	if !ok {
+		return f.dummy, false
+	}
+	return cred, true
+}
+
+func (f *UserFile) reloadIfChanged() {
+	info, err := os.Stat(f.path)
+	f.mu.Lock()
+	changed := err == nil && (!info.ModTime().Equal(f.modTime) || info.Size() != f.size)
+	f.checked = time.Now()
+	f.mu.Unlock()
+	if !changed {
+		return
+	}
+	// Keep serving the old users if the new file is broken
+	if err := f.reload(); err != nil {
+		fmt.Println("couldn't reload user file: ", err)
+	}
+}
+
+// checkPassword hashes password with the stored salt and parameters, and
+// compares it against the stored hash in constant time
+func (c credential) checkPassword(password string) bool {
+	p := c.params
+	sum := argon2.IDKey([]byte(password), c.salt, p.Time, p.Memory, p.Threads, uint32(len(c.hash)))
+	return subtle.ConstantTimeCompare(sum, c.hash) == 1
+}
+
+// HashPassword produces the hash field of a user file line, an argon2id hash
+// with a random salt and DefaultHashParams
+func HashPassword(password string) string {
+	return HashPasswordParams(password, DefaultHashParams)
+}
+
+// HashPasswordParams is HashPassword with the given cost parameters
+func HashPasswordParams(password string, p HashParams) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	sum := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum))
+}
+
+// DigestHA1 produces the digest field of a user file line for a realm
+func DigestHA1(username, realm, password string) string {
+	return sha256Hex(username + ":" + realm + ":" + password)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,198 @@
+// Package byterange handles HTTP byte-range requests (RFC 9110 section 14)
+package byterange
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+// maxRanges caps how many ranges a single request may ask for. Requests with
+// more than this are served in full rather than as a multipart response.
+const maxRanges = 64
+
+var ErrUnsatisfiable = errors.New("range not satisfiable")
+
+type Range struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange formats the range as a Content-Range header value
+func (r Range) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// UnsatisfiedRange is the Content-Range value sent alongside a 416
+func UnsatisfiedRange(size int64) string {
+	return fmt.Sprintf("bytes */%d", size)
+}
+
+// Parse will parse a Range header value against a representation of size bytes.
+// A nil slice and nil error means the header should be ignored and the full
+// representation served. ErrUnsatisfiable is returned if none of the
+// requested ranges overlap the representation.
+func Parse(header string, size int64) ([]Range, error) {
+	unit, set, found := strings.Cut(strings.TrimSpace(header), "=")
+	if !found || !strings.EqualFold(unit, "bytes") {
+		// Unknown range units must be ignored
+		return nil, nil
+	}
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+
+	ranges := []Range{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, nil
+		}
+		first = strings.TrimSpace(first)
+		last = strings.TrimSpace(last)
+
+		// Suffix range, e.g. "-500" is the final 500 bytes
+		if first == "" {
+			n, err := parsePos(last)
+			if err != nil {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, Range{Start: size - n, Length: n})
+			continue
+		}
+
+		start, err := parsePos(first)
+		if err != nil {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parsePos(last)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, Range{Start: start, Length: end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiable
+	}
+	return ranges, nil
+}
+
+func parsePos(s string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty range position")
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid range position: %q", s)
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// IfRangeMatches reports whether an If-Range precondition holds for a
+// representation with the given entity tag and modification time. When it
+// doesn't, the Range header must be ignored.
+func IfRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// Only a strong comparison is allowed for If-Range
+		return !strings.HasPrefix(ifRange, "W/") &&
+			!strings.HasPrefix(etag, "W/") &&
+			ifRange == etag
+	}
+	t, err := time.Parse(headers.TimeFormat, ifRange)
+	if err != nil {
+		return false
+	}
+	return t.Equal(modTime.UTC().Truncate(time.Second))
+}
+
+// Multipart writes a multipart/byteranges body for the given ranges
+type Multipart struct {
+	Boundary    string
+	ContentType string
+	Size        int64
+	Ranges      []Range
+}
+
+func NewMultipart(contentType string, size int64, ranges []Range) *Multipart {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return &Multipart{
+		Boundary:    hex.EncodeToString(b),
+		ContentType: contentType,
+		Size:        size,
+		Ranges:      ranges,
+	}
+}
+
+// MediaType is the Content-Type header value for the full response
+func (m *Multipart) MediaType() string {
+	return "multipart/byteranges; boundary=" + m.Boundary
+}
+
+func (m *Multipart) partHeader(i int) string {
+	prefix := "\r\n"
+	if i == 0 {
+		prefix = ""
+	}
+	return fmt.Sprintf("%s--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+		prefix, m.Boundary, m.ContentType, m.Ranges[i].ContentRange(m.Size))
+}
+
+func (m *Multipart) closer() string {
+	return fmt.Sprintf("\r\n--%s--\r\n", m.Boundary)
+}
+
+// Len returns the exact length of the body so that a Content-Length can be
+// sent without buffering the parts
+func (m *Multipart) Len() int64 {
+	var n int64
+	for i, r := range m.Ranges {
+		n += int64(len(m.partHeader(i))) + r.Length
+	}
+	return n + int64(len(m.closer()))
+}
+
+// Copy reads each range from src and writes the multipart body to dst
+func (m *Multipart) Copy(dst io.Writer, src io.ReaderAt) (int64, error) {
+	var total int64
+	for i, r := range m.Ranges {
+		n, err := io.WriteString(dst, m.partHeader(i))
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		c, err := io.Copy(dst, io.NewSectionReader(src, r.Start, r.Length))
+		total += c
+		if err != nil {
+			return total, err
+		}
+	}
+	n, err := io.WriteString(dst, m.closer())
+	total += int64(n)
+	return total, err
+}
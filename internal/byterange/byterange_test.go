@@ -0,0 +1,107 @@
+package byterange
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Single closed range
+	ranges, err := Parse("bytes=0-499", 1000)
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, Range{Start: 0, Length: 500}, ranges[0])
+	assert.Equal(t, "bytes 0-499/1000", ranges[0].ContentRange(1000))
+
+	// Test: Open ended range
+	ranges, err = Parse("bytes=900-", 1000)
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, Range{Start: 900, Length: 100}, ranges[0])
+
+	// Test: Suffix range
+	ranges, err = Parse("bytes=-100", 1000)
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, Range{Start: 900, Length: 100}, ranges[0])
+
+	// Test: Suffix longer than representation
+	ranges, err = Parse("bytes=-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, Range{Start: 0, Length: 1000}, ranges[0])
+
+	// Test: Last position past the end is clamped
+	ranges, err = Parse("bytes=500-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, Range{Start: 500, Length: 500}, ranges[0])
+
+	// Test: Multiple ranges with whitespace
+	ranges, err = Parse("bytes=0-9, 20-29 ,-5", 100)
+	require.NoError(t, err)
+	require.Len(t, ranges, 3)
+	assert.Equal(t, Range{Start: 95, Length: 5}, ranges[2])
+
+	// Test: Unsatisfiable range
+	_, err = Parse("bytes=1000-", 1000)
+	require.ErrorIs(t, err, ErrUnsatisfiable)
+
+	// Test: Unsatisfiable parts are dropped
+	ranges, err = Parse("bytes=2000-3000, 0-1", 1000)
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+
+	// Test: Unknown unit is ignored
+	ranges, err = Parse("items=0-1", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	// Test: Malformed range is ignored
+	ranges, err = Parse("bytes=10-5", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+	ranges, err = Parse("bytes=a-b", 1000)
+	require.NoError(t, err)
+	assert.Nil(t, ranges)
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 30, 45, 500, time.UTC)
+
+	// Test: Matching strong etag
+	assert.True(t, IfRangeMatches(`"abc"`, `"abc"`, modTime))
+
+	// Test: Weak etags never match
+	assert.False(t, IfRangeMatches(`W/"abc"`, `"abc"`, modTime))
+
+	// Test: Differing etag
+	assert.False(t, IfRangeMatches(`"xyz"`, `"abc"`, modTime))
+
+	// Test: Matching date
+	assert.True(t, IfRangeMatches("Fri, 01 Mar 2024 12:30:45 GMT", `"abc"`, modTime))
+
+	// Test: Stale date
+	assert.False(t, IfRangeMatches("Fri, 01 Mar 2024 12:00:00 GMT", `"abc"`, modTime))
+}
+
+func TestMultipart(t *testing.T) {
+	src := strings.NewReader("0123456789abcdefghij")
+	ranges := []Range{{Start: 0, Length: 3}, {Start: 10, Length: 2}}
+	mp := NewMultipart("text/plain", 20, ranges)
+
+	buf := bytes.Buffer{}
+	n, err := mp.Copy(&buf, src)
+	require.NoError(t, err)
+	assert.Equal(t, mp.Len(), n)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	body := buf.String()
+	assert.True(t, strings.HasPrefix(body, "--"+mp.Boundary+"\r\n"))
+	assert.Contains(t, body, "Content-Range: bytes 0-2/20\r\n\r\n012\r\n")
+	assert.Contains(t, body, "Content-Range: bytes 10-11/20\r\n\r\nab\r\n")
+	assert.True(t, strings.HasSuffix(body, "\r\n--"+mp.Boundary+"--\r\n"))
+}
@@ -0,0 +1,429 @@
+// Package cache is an RFC 9111 shared HTTP cache for proxied upstream responses
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+type Response struct {
+	StatusCode   int
+	Headers      *headers.Headers
+	Body         []byte
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func (r *Response) cacheControl() directives {
+	return parseCacheControl(get(r.Headers, "cache-control"))
+}
+
+// size is an estimate of the memory held by the response
+func (r *Response) size() int64 {
+	n := int64(len(r.Body))
+	for k, v := range r.Headers.All() {
+		n += int64(len(k) + len(v))
+	}
+	return n
+}
+
+// Fetcher retrieves url from the upstream server, sending the given request
+// headers (which may include conditional validators added by the cache). It
+// should give up once ctx is done.
+type Fetcher func(ctx context.Context, url string, reqHeaders *headers.Headers) (*Response, error)
+
+// refreshTimeout bounds a stale-while-revalidate refresh, which no caller
+// is waiting on to give up
+const refreshTimeout = 30 * time.Second
+
+type entry struct {
+	key  string
+	resp *Response
+}
+
+type call struct {
+	wg   sync.WaitGroup
+	resp *Response
+	err  error
+}
+
+type Cache struct {
+	fetch          Fetcher
+	maxBytes       int64
+	disk           *Disk
+	now            func() time.Time
+	refreshTimeout time.Duration
+
+	// ctx is cancelled by Close, stopping the background refreshes
+	ctx       context.Context
+	cancel    context.CancelFunc
+	refreshes sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	vary     map[string][]string
+	inflight map[string]*call
+}
+
+// New creates a cache holding at most maxBytes of responses in memory
+func New(maxBytes int64, fetch Fetcher) *Cache {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Cache{
+		fetch:          fetch,
+		maxBytes:       maxBytes,
+		now:            time.Now,
+		refreshTimeout: refreshTimeout,
+		ctx:            ctx,
+		cancel:         cancel,
+		lru:            list.New(),
+		entries:        map[string]*list.Element{},
+		vary:           map[string][]string{},
+		inflight:       map[string]*call{},
+	}
+}
+
+// Close cancels any background refreshes and waits for them to return.
+// Responses are still served afterwards, but stale ones are no longer
+// refreshed in the background.
+func (c *Cache) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.cancel()
+	c.refreshes.Wait()
+}
+
+// WithDisk adds a disk tier which responses evicted from memory spill into
+func (c *Cache) WithDisk(d *Disk) *Cache {
+	c.disk = d
+	return c
+}
+
+// Get returns the response for a GET of url, from the cache when a usable
+// response is stored and otherwise from upstream
+func (c *Cache) Get(url string, reqHeaders *headers.Headers) (*Response, error) {
+	ctx := context.Background()
+	reqCC := parseCacheControl(get(reqHeaders, "cache-control"))
+	if reqCC.has("no-store") {
+		return c.fetch(ctx, url, reqHeaders)
+	}
+
+	key := c.key(url, reqHeaders)
+	stored := c.lookup(key)
+	if stored == nil {
+		return c.do(key, func() (*Response, error) {
+			return c.fetchAndStore(ctx, url, reqHeaders)
+		})
+	}
+
+	now := c.now()
+	age := currentAge(stored, now)
+	lifetime := freshnessLifetime(stored)
+	if maxAge, ok := reqCC.seconds("max-age"); ok {
+		lifetime = min(lifetime, maxAge)
+	}
+	cc := stored.cacheControl()
+	mustRevalidate := reqCC.has("no-cache") || cc.has("no-cache")
+
+	if !mustRevalidate && age < lifetime {
+		return served(stored, age), nil
+	}
+
+	staleFor := age - lifetime
+	if swr, ok := cc.seconds("stale-while-revalidate"); ok && !mustRevalidate && staleFor < swr {
+		c.refresh(url, reqHeaders, key, stored)
+		return served(stored, age), nil
+	}
+
+	resp, err := c.do(key, func() (*Response, error) {
+		return c.revalidate(ctx, url, reqHeaders, key, stored)
+	})
+	if err != nil || resp.StatusCode >= 500 {
+		sie, ok := cc.seconds("stale-if-error")
+		if !ok {
+			sie, ok = reqCC.seconds("stale-if-error")
+		}
+		if ok && !cc.has("must-revalidate") && staleFor < sie {
+			return served(stored, age), nil
+		}
+	}
+	return resp, err
+}
+
+// do makes sure only one upstream request for a key is in flight at a time,
+// with concurrent callers sharing its result
+func (c *Cache) do(key string, fn func() (*Response, error)) (*Response, error) {
+	c.mu.Lock()
+	if inflight, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		inflight.wg.Wait()
+		return inflight.resp, inflight.err
+	}
+	cl := c.startLocked(key)
+	c.mu.Unlock()
+	return c.finish(key, cl, fn)
+}
+
+// startLocked registers a call in flight for key. c.mu must be held.
+func (c *Cache) startLocked(key string) *call {
+	cl := &call{}
+	cl.wg.Add(1)
+	c.inflight[key] = cl
+	return cl
+}
+
+// finish runs fn for a call started with startLocked, handing its result to
+// any callers waiting on it
+func (c *Cache) finish(key string, cl *call, fn func() (*Response, error)) (*Response, error) {
+	cl.resp, cl.err = fn()
+	cl.wg.Done()
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	return cl.resp, cl.err
+}
+
+// refresh revalidates a stored response in the background, unless a request
+// for it is already in flight. It gives up after refreshTimeout, or once the
+// cache is closed, so a stalled upstream can't leave refreshes piling up.
+func (c *Cache) refresh(url string, reqHeaders *headers.Headers, key string, stored *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.inflight[key]; ok || c.closed {
+		return
+	}
+	// The call is registered before returning so later requests see it
+	cl := c.startLocked(key)
+	c.refreshes.Add(1)
+	go func() {
+		defer c.refreshes.Done()
+		ctx, cancel := context.WithTimeout(c.ctx, c.refreshTimeout)
+		defer cancel()
+		_, err := c.finish(key, cl, func() (*Response, error) {
+			return c.revalidate(ctx, url, reqHeaders, key, stored)
+		})
+		if err != nil {
+			fmt.Println("couldn't refresh cache entry: ", err)
+		}
+	}()
+}
+
+// validators are the conditional request fields. A client's own are never
+// sent upstream when filling the cache, or the 304 that came back would be
+// all there was to store.
+var validators = []string{"if-none-match", "if-modified-since", "if-match", "if-unmodified-since", "if-range"}
+
+func withoutValidators(h *headers.Headers) *headers.Headers {
+	h = h.Clone()
+	for _, name := range validators {
+		h.Del(name)
+	}
+	return h
+}
+
+func (c *Cache) fetchAndStore(ctx context.Context, url string, reqHeaders *headers.Headers) (*Response, error) {
+	reqTime := c.now()
+	resp, err := c.fetch(ctx, url, withoutValidators(reqHeaders))
+	if err != nil {
+		return nil, err
+	}
+	normalise(resp, reqTime, c.now())
+	c.store(url, reqHeaders, resp)
+	return served(resp, currentAge(resp, c.now())), nil
+}
+
+func (c *Cache) revalidate(ctx context.Context, url string, reqHeaders *headers.Headers, key string, stored *Response) (*Response, error) {
+	conditional := withoutValidators(reqHeaders)
+	if etag, ok := stored.Headers.Get("etag"); ok {
+		conditional.Set("If-None-Match", etag)
+	}
+	if lastModified, ok := stored.Headers.Get("last-modified"); ok {
+		conditional.Set("If-Modified-Since", lastModified)
+	}
+
+	reqTime := c.now()
+	resp, err := c.fetch(ctx, url, conditional)
+	if err != nil {
+		return nil, err
+	}
+	normalise(resp, reqTime, c.now())
+	if resp.StatusCode >= 500 {
+		// Keep the stored response around so it can be served if stale-if-error allows
+		return served(resp, currentAge(resp, c.now())), nil
+	}
+	if resp.StatusCode != 304 {
+		c.remove(key)
+		c.store(url, reqHeaders, resp)
+		return served(resp, currentAge(resp, c.now())), nil
+	}
+
+	// Freshen the stored response with the headers from the 304
+	updated := &Response{
+		StatusCode:   stored.StatusCode,
+		Headers:      stored.Headers.Clone(),
+		Body:         stored.Body,
+		RequestTime:  resp.RequestTime,
+		ResponseTime: resp.ResponseTime,
+	}
+	for k := range resp.Headers.All() {
+		if !strings.EqualFold(k, "content-length") {
+			updated.Headers.Del(k)
+		}
+	}
+	for k, v := range resp.Headers.All() {
+		if !strings.EqualFold(k, "content-length") {
+			updated.Headers.Add(k, v)
+		}
+	}
+	c.store(url, reqHeaders, updated)
+	return served(updated, currentAge(updated, c.now())), nil
+}
+
+// key combines the url with the request's values for any header fields the
+// stored response for url varies on
+func (c *Cache) key(url string, reqHeaders *headers.Headers) string {
+	c.mu.Lock()
+	fields := c.vary[url]
+	c.mu.Unlock()
+	return secondaryKey(url, fields, reqHeaders)
+}
+
+func secondaryKey(url string, fields []string, reqHeaders *headers.Headers) string {
+	b := strings.Builder{}
+	b.WriteString(url)
+	for _, field := range fields {
+		v, _ := reqHeaders.Get(field)
+		fmt.Fprintf(&b, "\x00%s=%s", field, strings.Join(strings.Fields(v), " "))
+	}
+	return b.String()
+}
+
+func (c *Cache) lookup(key string) *Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*entry).resp
+	}
+	if c.disk == nil {
+		return nil
+	}
+	resp, ok := c.disk.load(key)
+	if !ok {
+		return nil
+	}
+	c.insert(key, resp)
+	return resp
+}
+
+func (c *Cache) store(url string, reqHeaders *headers.Headers, resp *Response) {
+	if !storable(resp, reqHeaders) {
+		return
+	}
+	fields := varyFields(resp)
+	key := secondaryKey(url, fields, reqHeaders)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vary[url] = fields
+	c.insert(key, resp)
+}
+
+// insert adds a response to the front of the LRU list, evicting the least
+// recently used entries until it fits within the byte budget. c.mu must be held.
+func (c *Cache) insert(key string, resp *Response) {
+	c.removeLocked(key)
+	size := resp.size()
+	if size > c.maxBytes {
+		c.spill(key, resp)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, resp: resp})
+	c.size += size
+	for c.size > c.maxBytes {
+		oldest := c.lru.Back()
+		e := oldest.Value.(*entry)
+		c.removeLocked(e.key)
+		c.spill(e.key, e.resp)
+	}
+}
+
+func (c *Cache) spill(key string, resp *Response) {
+	if c.disk == nil {
+		return
+	}
+	if err := c.disk.save(key, resp); err != nil {
+		fmt.Println("couldn't write cache entry to disk: ", err)
+	}
+}
+
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+	if c.disk != nil {
+		c.disk.remove(key)
+	}
+}
+
+func (c *Cache) removeLocked(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.size -= el.Value.(*entry).resp.size()
+	c.lru.Remove(el)
+	delete(c.entries, key)
+}
+
+// Size returns the number of bytes of responses held in memory
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func varyFields(resp *Response) []string {
+	fields := []string{}
+	for _, field := range strings.Split(get(resp.Headers, "vary"), ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field != "" && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// normalise takes a copy of the headers, so the fetcher can't change the
+// stored response, and records when the exchange happened
+func normalise(resp *Response, reqTime, respTime time.Time) {
+	resp.Headers = resp.Headers.Clone()
+	resp.RequestTime = reqTime
+	resp.ResponseTime = respTime
+}
+
+// served copies a stored response for handing to a caller with its Age set
+func served(resp *Response, age time.Duration) *Response {
+	h := resp.Headers.Clone()
+	h.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return &Response{
+		StatusCode:   resp.StatusCode,
+		Headers:      h,
+		Body:         resp.Body,
+		RequestTime:  resp.RequestTime,
+		ResponseTime: resp.ResponseTime,
+	}
+}
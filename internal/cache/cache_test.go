@@ -0,0 +1,423 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestCache(maxBytes int64, fetch Fetcher) (*Cache, *clock) {
+	clk := &clock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c := New(maxBytes, fetch)
+	c.now = clk.now
+	return c, clk
+}
+
+// newHeaders builds headers from name, value pairs
+func newHeaders(pairs ...string) *headers.Headers {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestFreshness(t *testing.T) {
+	var calls atomic.Int32
+	var c *Cache
+	var clk *clock
+	c, clk = newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		calls.Add(1)
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Cache-Control", "max-age=60",
+				"Date", clk.now().Format(headers.TimeFormat),
+			),
+			Body: []byte("hello"),
+		}, nil
+	})
+
+	// Test: Miss goes upstream
+	resp, err := c.Get("http://example.com/a", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Test: Fresh hit is served from the cache with its age
+	clk.advance(30 * time.Second)
+	resp, err = c.Get("http://example.com/a", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	age, _ := resp.Headers.Get("age")
+	assert.Equal(t, "30", age)
+
+	// Test: Request max-age forces a refetch
+	resp, err = c.Get("http://example.com/a", newHeaders("cache-control", "max-age=10"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: Stale entry goes upstream
+	clk.advance(61 * time.Second)
+	_, err = c.Get("http://example.com/a", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// Test: no-store requests bypass the cache
+	_, err = c.Get("http://example.com/a", newHeaders("cache-control", "no-store"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestExpiresAndAge(t *testing.T) {
+	var calls atomic.Int32
+	var c *Cache
+	var clk *clock
+	c, clk = newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		calls.Add(1)
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Date", clk.now().Format(headers.TimeFormat),
+				"Expires", clk.now().Add(100*time.Second).Format(headers.TimeFormat),
+				"Age", "90",
+			),
+		}, nil
+	})
+
+	_, err := c.Get("http://example.com/b", headers.NewHeaders())
+	require.NoError(t, err)
+
+	// Test: Upstream Age counts towards the freshness lifetime
+	clk.advance(5 * time.Second)
+	resp, err := c.Get("http://example.com/b", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	age, _ := resp.Headers.Get("age")
+	assert.Equal(t, "95", age)
+
+	clk.advance(10 * time.Second)
+	_, err = c.Get("http://example.com/b", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRevalidation(t *testing.T) {
+	var conditional *headers.Headers
+	var c *Cache
+	var clk *clock
+	c, clk = newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		if _, ok := h.Get("if-none-match"); ok {
+			conditional = h
+			return &Response{
+				StatusCode: 304,
+				Headers: newHeaders(
+					"Cache-Control", "no-cache",
+					"X-Revalidated", "yes",
+				),
+			}, nil
+		}
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Cache-Control", "no-cache",
+				"ETag", `"v1"`,
+				"Last-Modified", clk.now().Add(-time.Hour).Format(headers.TimeFormat),
+			),
+			Body: []byte("body"),
+		}, nil
+	})
+
+	_, err := c.Get("http://example.com/c", headers.NewHeaders())
+	require.NoError(t, err)
+
+	// Test: no-cache responses are revalidated with validators
+	resp, err := c.Get("http://example.com/c", headers.NewHeaders())
+	require.NoError(t, err)
+	require.NotNil(t, conditional)
+	etag, _ := conditional.Get("if-none-match")
+	assert.Equal(t, `"v1"`, etag)
+	assert.True(t, conditional.Has("if-modified-since"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "body", string(resp.Body))
+	revalidated, _ := resp.Headers.Get("x-revalidated")
+	assert.Equal(t, "yes", revalidated)
+
+	// Test: A client's validators aren't sent upstream on a fill
+	conditional = nil
+	resp, err = c.Get("http://example.com/d", newHeaders("If-None-Match", `"v1"`))
+	require.NoError(t, err)
+	assert.Nil(t, conditional)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "body", string(resp.Body))
+
+	// Test: A client's validators are replaced on revalidation
+	_, err = c.Get("http://example.com/d", newHeaders("If-None-Match", `"other"`))
+	require.NoError(t, err)
+	require.NotNil(t, conditional)
+	assert.Equal(t, []string{`"v1"`}, conditional.Values("if-none-match"))
+
+	// Test: Partial and bodiless responses aren't stored
+	for _, status := range []int{100, 206, 304} {
+		resp := &Response{StatusCode: status, Headers: newHeaders("Cache-Control", "public, max-age=60")}
+		assert.False(t, storable(resp, headers.NewHeaders()), status)
+	}
+}
+
+func TestStaleDirectives(t *testing.T) {
+	fail := false
+	var calls atomic.Int32
+	var c *Cache
+	var clk *clock
+	c, clk = newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		calls.Add(1)
+		if fail {
+			return nil, errors.New("upstream down")
+		}
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Cache-Control", "max-age=10, stale-if-error=60",
+				"Date", clk.now().Format(headers.TimeFormat),
+			),
+			Body: []byte("cached"),
+		}, nil
+	})
+	_, err := c.Get("http://example.com/d", headers.NewHeaders())
+	require.NoError(t, err)
+
+	// Test: stale-if-error serves the stored response when upstream fails
+	fail = true
+	clk.advance(30 * time.Second)
+	resp, err := c.Get("http://example.com/d", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, "cached", string(resp.Body))
+
+	// Test: Outside the stale-if-error window the error is returned
+	clk.advance(60 * time.Second)
+	_, err = c.Get("http://example.com/d", headers.NewHeaders())
+	require.Error(t, err)
+
+	// Test: stale-while-revalidate serves stale and refreshes in the background
+	revalidated := make(chan struct{}, 1)
+	c, clk = newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		if calls.Add(1) > 1 {
+			revalidated <- struct{}{}
+		}
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Cache-Control", "max-age=10, stale-while-revalidate=30",
+				"Date", clk.now().Format(headers.TimeFormat),
+			),
+		}, nil
+	})
+	calls.Store(0)
+	_, err = c.Get("http://example.com/e", headers.NewHeaders())
+	require.NoError(t, err)
+	clk.advance(20 * time.Second)
+	resp, err = c.Get("http://example.com/e", headers.NewHeaders())
+	require.NoError(t, err)
+	age, _ := resp.Headers.Get("age")
+	assert.Equal(t, "20", age)
+	select {
+	case <-revalidated:
+	case <-time.After(time.Second):
+		t.Fatal("background revalidation never happened")
+	}
+	c.Close()
+
+	// Test: Background refreshes against a stalled upstream don't pile up,
+	// and give up after the refresh timeout
+	stalled := make(chan error, 10)
+	c, clk = newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		if calls.Add(1) > 1 {
+			<-ctx.Done()
+			stalled <- ctx.Err()
+			return nil, ctx.Err()
+		}
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Cache-Control", "max-age=10, stale-while-revalidate=30",
+				"Date", clk.now().Format(headers.TimeFormat),
+			),
+		}, nil
+	})
+	c.refreshTimeout = 50 * time.Millisecond
+	calls.Store(0)
+	_, err = c.Get("http://example.com/h", headers.NewHeaders())
+	require.NoError(t, err)
+	clk.advance(20 * time.Second)
+	for range 5 {
+		_, err = c.Get("http://example.com/h", headers.NewHeaders())
+		require.NoError(t, err)
+	}
+	select {
+	case err := <-stalled:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("stalled refresh never gave up")
+	}
+	c.Close()
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: Close cancels a refresh in progress
+	c.refreshTimeout = time.Minute
+	c.closed = false
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	_, err = c.Get("http://example.com/h", headers.NewHeaders())
+	require.NoError(t, err)
+	c.Close()
+	assert.ErrorIs(t, <-stalled, context.Canceled)
+}
+
+func TestVary(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		calls.Add(1)
+		lang, _ := h.Get("accept-language")
+		return &Response{
+			StatusCode: 200,
+			Headers: newHeaders(
+				"Cache-Control", "max-age=60",
+				"Vary", "Accept-Language",
+			),
+			Body: []byte(lang),
+		}, nil
+	})
+
+	en := newHeaders("accept-language", "en")
+	fr := newHeaders("accept-language", "fr")
+	_, err := c.Get("http://example.com/f", en)
+	require.NoError(t, err)
+
+	// Test: A different variant is fetched separately
+	resp, err := c.Get("http://example.com/f", fr)
+	require.NoError(t, err)
+	assert.Equal(t, "fr", string(resp.Body))
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Test: Both variants are now cached
+	resp, err = c.Get("http://example.com/f", en)
+	require.NoError(t, err)
+	assert.Equal(t, "en", string(resp.Body))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCoalescing(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c, _ := newTestCache(1<<20, func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		calls.Add(1)
+		<-release
+		return &Response{StatusCode: 200, Headers: newHeaders("Cache-Control", "max-age=60")}, nil
+	})
+
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Get("http://example.com/g", headers.NewHeaders())
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestEviction(t *testing.T) {
+	var calls atomic.Int32
+	fetch := func(ctx context.Context, url string, h *headers.Headers) (*Response, error) {
+		calls.Add(1)
+		return &Response{
+			StatusCode: 200,
+			Headers:    newHeaders("Cache-Control", "max-age=60"),
+			Body:       make([]byte, 100),
+		}, nil
+	}
+
+	// Test: Least recently used entry is evicted once over budget
+	c, _ := newTestCache(300, fetch)
+	for i := range 3 {
+		_, err := c.Get(fmt.Sprintf("http://example.com/%d", i), headers.NewHeaders())
+		require.NoError(t, err)
+	}
+	assert.LessOrEqual(t, c.Size(), int64(300))
+	assert.Equal(t, int32(3), calls.Load())
+	_, err := c.Get("http://example.com/2", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+	_, err = c.Get("http://example.com/0", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), calls.Load())
+
+	// Test: Evicted entries spill to the disk tier
+	calls.Store(0)
+	dir := t.TempDir()
+	disk, err := NewDisk(dir, 1<<20)
+	require.NoError(t, err)
+	c, _ = newTestCache(300, fetch)
+	c.WithDisk(disk)
+	for i := range 3 {
+		_, err := c.Get(fmt.Sprintf("http://example.com/%d", i), headers.NewHeaders())
+		require.NoError(t, err)
+	}
+	_, err = c.Get("http://example.com/0", headers.NewHeaders())
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// Test: The disk tier evicts its least recently used files over budget
+	dir = t.TempDir()
+	resp := &Response{StatusCode: 200, Headers: newHeaders("Cache-Control", "max-age=60"), Body: make([]byte, 100)}
+	disk, err = NewDisk(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, disk.save("a", resp))
+	fileSize := disk.Size()
+	disk, err = NewDisk(dir, 2*fileSize)
+	require.NoError(t, err)
+	require.NoError(t, disk.save("a", resp))
+	require.NoError(t, disk.save("b", resp))
+	_, ok := disk.load("a")
+	require.True(t, ok)
+	require.NoError(t, disk.save("c", resp))
+	assert.LessOrEqual(t, disk.Size(), 2*fileSize)
+	_, ok = disk.load("b")
+	assert.False(t, ok)
+	_, ok = disk.load("a")
+	assert.True(t, ok)
+	_, ok = disk.load("c")
+	assert.True(t, ok)
+
+	// Test: Files left from before are counted against the budget
+	disk, err = NewDisk(dir, fileSize)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, disk.Size(), fileSize)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+// heuristicStatuses may be given a heuristic freshness lifetime when the
+// response carries no explicit expiration (RFC 9110 section 15.1)
+var heuristicStatuses = []int{200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501}
+
+// maxHeuristic caps the heuristic freshness lifetime
+const maxHeuristic = 24 * time.Hour
+
+var dateFormats = []string{headers.TimeFormat, time.RFC850, time.ANSIC}
+
+// directives holds the parsed Cache-Control directives of a message
+type directives map[string]string
+
+func parseCacheControl(value string) directives {
+	d := directives{}
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		d[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return d
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns a delta-seconds directive as a duration
+func (d directives) seconds(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func headerDate(h *headers.Headers, key string) (time.Time, bool) {
+	v, ok := h.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseDate(v)
+}
+
+// freshnessLifetime calculates how long a response is fresh for as a shared
+// cache (RFC 9111 section 4.2.1)
+func freshnessLifetime(resp *Response) time.Duration {
+	cc := resp.cacheControl()
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	date, hasDate := headerDate(resp.Headers, "date")
+	if !hasDate {
+		date = resp.ResponseTime
+	}
+	if expires, ok := resp.Headers.Get("expires"); ok {
+		t, ok := parseDate(expires)
+		if !ok {
+			// Invalid Expires values represent a time in the past
+			return 0
+		}
+		return max(t.Sub(date), 0)
+	}
+	if cc.has("public") || slices.Contains(heuristicStatuses, resp.StatusCode) {
+		if lastModified, ok := headerDate(resp.Headers, "last-modified"); ok && lastModified.Before(date) {
+			return min(date.Sub(lastModified)/10, maxHeuristic)
+		}
+	}
+	return 0
+}
+
+// currentAge calculates the age of a stored response (RFC 9111 section 4.2.3)
+func currentAge(resp *Response, now time.Time) time.Duration {
+	ageValue := time.Duration(0)
+	if v, ok := resp.Headers.Get("age"); ok {
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n >= 0 {
+			ageValue = time.Duration(n) * time.Second
+		}
+	}
+	apparentAge := time.Duration(0)
+	if date, ok := headerDate(resp.Headers, "date"); ok {
+		apparentAge = max(resp.ResponseTime.Sub(date), 0)
+	}
+	responseDelay := resp.ResponseTime.Sub(resp.RequestTime)
+	correctedAgeValue := ageValue + responseDelay
+	correctedInitialAge := max(apparentAge, correctedAgeValue)
+	residentTime := now.Sub(resp.ResponseTime)
+	return correctedInitialAge + residentTime
+}
+
+// storable reports whether a response to a GET may be stored by a shared
+// cache (RFC 9111 section 3). Only complete, final responses are stored, as
+// partial 206 and bodiless 304 responses can't be served on their own.
+func storable(resp *Response, reqHeaders *headers.Headers) bool {
+	if resp.StatusCode < 200 || resp.StatusCode == 206 || resp.StatusCode == 304 {
+		return false
+	}
+	reqCC := parseCacheControl(get(reqHeaders, "cache-control"))
+	cc := resp.cacheControl()
+	if reqCC.has("no-store") || cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if get(resp.Headers, "vary") == "*" {
+		return false
+	}
+	if _, ok := reqHeaders.Get("authorization"); ok &&
+		!cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+	_, hasExpires := resp.Headers.Get("expires")
+	return hasExpires ||
+		cc.has("max-age") ||
+		cc.has("s-maxage") ||
+		cc.has("public") ||
+		slices.Contains(heuristicStatuses, resp.StatusCode)
+}
+
+func get(h *headers.Headers, key string) string {
+	v, _ := h.Get(key)
+	return v
+}
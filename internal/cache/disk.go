@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Disk is a second cache tier that stores responses evicted from memory as
+// files in a directory, evicting the least recently used files once they
+// take up more than its byte budget
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	files map[string]*list.Element
+}
+
+type diskFile struct {
+	name string
+	size int64
+}
+
+// NewDisk opens a disk tier holding at most maxBytes of files in dir. Files
+// left by an earlier run are kept, oldest first in line for eviction, and
+// any over the budget are removed straight away.
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("couldn't create cache directory: %w", err)
+	}
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		files:    map[string]*list.Element{},
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read cache directory: %w", err)
+	}
+	type existing struct {
+		diskFile
+		modTime time.Time
+	}
+	found := []existing{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// A temporary file is what is left of a write that never finished
+		if strings.HasPrefix(e.Name(), "tmp-") {
+			os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		found = append(found, existing{diskFile{e.Name(), info.Size()}, info.ModTime()})
+	}
+	slices.SortFunc(found, func(a, b existing) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range found {
+		d.files[f.name] = d.lru.PushFront(&diskFile{f.name, f.size})
+		d.size += f.size
+	}
+	d.evictLocked()
+	return d, nil
+}
+
+func (d *Disk) name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *Disk) save(key string, resp *Response) error {
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(resp); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info.Size() > d.maxBytes {
+		return fmt.Errorf("response of %d bytes is over the disk budget", info.Size())
+	}
+
+	name := d.name(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, name)); err != nil {
+		return err
+	}
+	d.forgetLocked(name)
+	d.files[name] = d.lru.PushFront(&diskFile{name, info.Size()})
+	d.size += info.Size()
+	d.evictLocked()
+	return nil
+}
+
+func (d *Disk) load(key string) (*Response, bool) {
+	name := d.name(key)
+	d.mu.Lock()
+	el, ok := d.files[name]
+	if ok {
+		d.lru.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(filepath.Join(d.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	resp := &Response{}
+	if err := gob.NewDecoder(file).Decode(resp); err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+func (d *Disk) remove(key string) {
+	name := d.name(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.forgetLocked(name)
+	os.Remove(filepath.Join(d.dir, name))
+}
+
+// Size returns the number of bytes of files held on disk
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// evictLocked removes the least recently used files until the rest fit
+// within the byte budget. d.mu must be held.
+func (d *Disk) evictLocked() {
+	for d.size > d.maxBytes && d.lru.Len() > 0 {
+		f := d.lru.Back().Value.(*diskFile)
+		d.forgetLocked(f.name)
+		os.Remove(filepath.Join(d.dir, f.name))
+	}
+}
+
+func (d *Disk) forgetLocked(name string) {
+	el, ok := d.files[name]
+	if !ok {
+		return
+	}
+	d.size -= el.Value.(*diskFile).size
+	d.lru.Remove(el)
+	delete(d.files, name)
+}
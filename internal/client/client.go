@@ -0,0 +1,273 @@
+// Package client sends HTTP/1.1 requests, writing them with the request
+// package and reading the responses with its own parser
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+)
+
+var ErrUnsupportedScheme = errors.New("unsupported scheme")
+
+// Client sends requests, keeping connections to each host open between them
+// so they can be reused. A Client is safe for concurrent use, and must not be
+// copied once it has been used.
+type Client struct {
+	// Timeout bounds the whole exchange, from dialling to reading the end of
+	// the body. Zero means no timeout beyond the context's.
+	Timeout time.Duration
+	// Dial opens connections, defaulting to a net.Dialer. Tests can use it
+	// to serve requests over a net.Pipe.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig is used for https requests. Its ServerName defaults to the
+	// request's host.
+	TLSConfig *tls.Config
+
+	// DisableKeepAlives closes each connection after one request
+	DisableKeepAlives bool
+	// MaxIdleConns and MaxIdleConnsPerHost bound how many connections wait in
+	// the pool, in total and for each host. Zero uses the defaults, and a
+	// negative limit keeps no idle connections.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections open to each host, in use or
+	// idle, with requests waiting for one to be free. Zero means no limit.
+	MaxConnsPerHost int
+	// IdleTimeout is how long a connection can wait in the pool before it is
+	// closed. Zero uses DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	pool pool
+}
+
+// Get sends a GET request for target, which must be an absolute URL
+func (c *Client) Get(ctx context.Context, target string) (*Response, error) {
+	req, err := request.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Do sends req and reads the response head. The response body must be closed,
+// even if it isn't read, which hands the connection back to the pool once
+// the whole body has been read.
+//
+// Once ctx is done, or the Timeout has passed, any read or write on the
+// connection fails, including reads of the body.
+//
+// An idempotent request without a body is sent again on a new connection if
+// a reused one fails before any of the response arrives, as the server may
+// have closed it just as the request was sent (RFC 9112 section 9.3.1).
+func (c *Client) Do(ctx context.Context, req *request.Request) (*Response, error) {
+	scheme, addr, err := address(req)
+	if err != nil {
+		return nil, err
+	}
+	cancel := context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+	for {
+		pc, err := c.getConn(ctx, scheme, addr)
+		if err != nil {
+			err = wrapContextErr(ctx, err)
+			cancel()
+			return nil, err
+		}
+		resp, retry, err := c.send(ctx, cancel, pc, req)
+		if err == nil {
+			return resp, nil
+		}
+		if !retry || ctx.Err() != nil {
+			cancel()
+			return nil, err
+		}
+		c.pool.mu.Lock()
+		c.pool.stats.Retries++
+		c.pool.mu.Unlock()
+	}
+}
+
+// send writes req on pc and reads the response head, reporting whether a
+// failure can be retried on another connection
+func (c *Client) send(ctx context.Context, cancel context.CancelFunc, pc *persistConn, req *request.Request) (*Response, bool, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetDeadline(deadline)
+	}
+	// A deadline in the past wakes up anything blocked on the connection
+	stop := context.AfterFunc(ctx, func() {
+		pc.conn.SetDeadline(time.Unix(1, 0))
+	})
+	fail := func(err error, retry bool) (*Response, bool, error) {
+		err = wrapContextErr(ctx, err)
+		stop()
+		c.release(pc, false)
+		return nil, retry && pc.reused && replayable(req), err
+	}
+
+	if err := req.Write(pc.rw); err != nil {
+		return fail(fmt.Errorf("couldn't write request: %w", err), true)
+	}
+	// Nothing at all coming back on a reused connection means it was closed
+	// before the server saw the request
+	if _, err := pc.br.Peek(1); err != nil {
+		return fail(fmt.Errorf("couldn't read response: %w", err), true)
+	}
+	resp, err := readResponse(pc.br, req.RequestLine.Method)
+	if err != nil {
+		return fail(fmt.Errorf("couldn't read response: %w", err), false)
+	}
+	reusable := !resp.closeConn && !hasToken(req.Headers, "Connection", "close")
+	resp.Body = &connBody{
+		body:     resp.Body,
+		ctx:      ctx,
+		reusable: reusable,
+		done: func(reuse bool) {
+			stop()
+			cancel()
+			c.release(pc, reuse)
+		},
+	}
+	return resp, false, nil
+}
+
+func (c *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if c.Dial != nil {
+		return c.Dial(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (c *Client) handshake(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
+	cfg := &tls.Config{}
+	if c.TLSConfig != nil {
+		cfg = c.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("tls handshake with %s failed: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// address works out where to connect from the request target, or the Host
+// header for an origin-form target, adding the scheme's default port
+func address(req *request.Request) (string, string, error) {
+	scheme := req.Target.Scheme
+	host := req.Target.Host
+	if host == "" {
+		host, _ = req.Headers.Get("Host")
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+	if host == "" {
+		return "", "", request.ErrMissingHost
+	}
+	port := "80"
+	switch scheme {
+	case "http":
+	case "https":
+		port = "443"
+	default:
+		return "", "", fmt.Errorf("%w: %q", ErrUnsupportedScheme, scheme)
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return scheme, host, nil
+	}
+	return scheme, net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
+
+// wrapContextErr adds the context's error when it is why err happened, so
+// callers can check for context.Canceled or context.DeadlineExceeded
+func wrapContextErr(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	// The connection's deadline can pass just before the context notices
+	if deadline, ok := ctx.Deadline(); ok && ctxErr == nil &&
+		errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		ctxErr = context.DeadlineExceeded
+	}
+	if ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
+
+// replayable reports whether req can safely be sent again, being idempotent
+// (RFC 9110 section 9.2.2) with no body that would have to be read twice
+func replayable(req *request.Request) bool {
+	switch req.RequestLine.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return req.Body == request.NoBody
+	}
+	return false
+}
+
+// connBody hands the connection back once the body has been read to the end,
+// or closes it if the body is closed early
+type connBody struct {
+	body io.Reader
+	ctx  context.Context
+	// reusable is set when the connection can carry another request once
+	// the body is done with
+	reusable bool
+	done     func(reuse bool)
+	finished bool
+	closed   bool
+}
+
+func (b *connBody) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, request.ErrBodyClosed
+	}
+	// The connection may already be carrying another request
+	if b.finished {
+		return 0, io.EOF
+	}
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.finish(b.reusable)
+	} else if err != nil {
+		err = wrapContextErr(b.ctx, err)
+		b.finish(false)
+	}
+	return n, err
+}
+
+// Close reads what is left of a short body so the connection can be reused,
+// closing it instead if more than maxDrainSize bytes are left
+func (b *connBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	// Reading to the end hands the connection back, so only one with too
+	// much left is closed here
+	if !b.finished && b.reusable {
+		io.CopyN(io.Discard, b, maxDrainSize+1)
+	}
+	b.finish(false)
+	b.closed = true
+	return nil
+}
+
+func (b *connBody) finish(reuse bool) {
+	if b.finished {
+		return
+	}
+	b.finished = true
+	b.done(reuse)
+}
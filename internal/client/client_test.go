@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/2bitburrito/http-implementation/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeClient returns a client whose connections are served by s over a pipe
+func pipeClient(s *server.Server) *Client {
+	return &Client{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, conn := net.Pipe()
+			go s.ServeConn(conn)
+			return client, nil
+		},
+	}
+}
+
+func readBody(t *testing.T, resp *Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestClient(t *testing.T) {
+	s := &server.Server{Handler: func(w response.Writer, req *request.Request) {
+		switch req.Target.Path {
+		case "/chunked":
+			h := headers.NewHeaders()
+			h.Set("Trailer", "X-Checksum")
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("hello "))
+			w.WriteChunkedBody([]byte("world"))
+			w.WriteChunkedBodyDone()
+			trailers := headers.NewHeaders()
+			trailers.Set("X-Checksum", "abc")
+			w.(response.TrailerWriter).WriteTrailers(trailers)
+		case "/echo":
+			w.WriteStatusLine(response.Created)
+			response.Copy(w, req.Body)
+		default:
+			host, _ := req.Headers.Get("Host")
+			w.WriteBody([]byte(req.RequestLine.Method + " " + host + " " + req.Target.Path + "?" + req.Target.RawQuery))
+		}
+	}}
+	c := pipeClient(s)
+	ctx := context.Background()
+
+	// Test: Response with a Content-Length
+	resp, err := c.Get(ctx, "http://example.com/hello?a=1")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Reason)
+	assert.Equal(t, int64(26), resp.ContentLength)
+	assert.Equal(t, "GET example.com /hello?a=1", readBody(t, resp))
+
+	// Test: Chunked response with trailers
+	resp, err = c.Get(ctx, "http://example.com/chunked")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "hello world", readBody(t, resp))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Request body with a known length
+	req, err := request.NewRequest("POST", "http://example.com/echo", strings.NewReader("ping"))
+	require.NoError(t, err)
+	resp, err = c.Do(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, response.Created, resp.StatusCode)
+	assert.Equal(t, "ping", readBody(t, resp))
+
+	// Test: Request body sent chunked
+	req, err = request.NewRequest("POST", "http://example.com/echo", io.MultiReader(strings.NewReader("pi"), strings.NewReader("ng")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), req.ContentLength)
+	resp, err = c.Do(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", readBody(t, resp))
+
+	// Test: Body read after it is closed
+	resp, err = c.Get(ctx, "http://example.com/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	_, err = resp.Body.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, request.ErrBodyClosed)
+
+	// Test: The server's connections aren't kept, as it says it closes them
+	// even where the handler left Connection out
+	stats := c.Stats()
+	assert.Equal(t, int64(5), stats.Dials)
+	assert.Equal(t, int64(0), stats.Reuses)
+	assert.Equal(t, int64(0), stats.Stale)
+	assert.Equal(t, 0, stats.Open)
+
+	// Test: Unsupported scheme
+	_, err = c.Get(ctx, "ftp://example.com/")
+	assert.Error(t, err)
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := &server.Server{Handler: func(w response.Writer, req *request.Request) {
+		<-release
+		w.WriteStatusLine(response.OK)
+	}}
+
+	// Test: Timeout while waiting for the response
+	c := pipeClient(s)
+	c.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	_, err := c.Get(context.Background(), "http://example.com/")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: Context cancelled while waiting for the response
+	c.Timeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = c.Get(ctx, "http://example.com/")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Test: Context already cancelled
+	_, err = c.Get(ctx, "http://example.com/")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestResponseFromReader(t *testing.T) {
+	// Test: Content-Length body, leaving what follows on the reader
+	resp, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusCode)
+	contentType, _ := resp.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, "hello", readBody(t, resp))
+
+	// Test: Chunked body with trailers
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, resp))
+	sum, _ := resp.Trailers.Get("X-Sum")
+	assert.Equal(t, "1", sum)
+
+	// Test: Body delimited by the connection closing
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nuntil the end"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "until the end", readBody(t, resp))
+
+	// Test: Interim responses are skipped
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, response.NoContent, resp.StatusCode)
+	assert.False(t, resp.Headers.Has("Link"))
+	assert.Equal(t, "", readBody(t, resp))
+
+	// Test: No body for a 304, whatever its Content-Length
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\nContent-Length: 10\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", readBody(t, resp))
+
+	// Test: Empty reason and bare LF line endings
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 599\nContent-Length: 0\n\n"))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(599), resp.StatusCode)
+	assert.Equal(t, "", resp.Reason)
+
+	// Test: Repeated matching Content-Length
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2, 2\r\n\r\nok"))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readBody(t, resp))
+
+	// Test: Truncated body
+	resp, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, ErrBodyTruncated)
+
+	// Test: Malformed responses
+	for _, raw := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"HTTP/1.1200 OK\r\n\r\n",
+	} {
+		_, err = ResponseFromReader(strings.NewReader(raw))
+		assert.ErrorIs(t, err, ErrMalformedStatusLine, raw)
+	}
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2, 3\r\n\r\nok"))
+	assert.ErrorIs(t, err, request.ErrInvalidContentLength)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n"))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nX: " + strings.Repeat("a", MaxResponseLineSize) + "\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrResponseLineTooLong)
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+)
+
+// The pool limits used when the Client's fields are left at zero
+const (
+	DefaultMaxIdleConnsPerHost = 2
+	DefaultMaxIdleConns        = 100
+	DefaultIdleTimeout         = 90 * time.Second
+)
+
+// maxDrainSize is how much of an unread body Close will read so that the
+// connection can go back to the pool
+const maxDrainSize = 64 << 10
+
+// PoolStats counts what a Client's connection pool has done
+type PoolStats struct {
+	// Dials is how many connections have been opened
+	Dials int64
+	// Reuses is how many requests were sent on an idle connection
+	Reuses int64
+	// Stale is how many idle connections were found closed by the server
+	// when they were taken from the pool
+	Stale int64
+	// Retries is how many requests were sent again after a reused
+	// connection failed before any of the response arrived
+	Retries int64
+	// Open is how many connections are open now, and Idle how many of
+	// those are waiting in the pool
+	Open int
+	Idle int
+}
+
+// pool holds the idle connections of a Client, keyed by scheme and address
+type pool struct {
+	mu    sync.Mutex
+	hosts map[string]*hostConns
+	stats PoolStats
+}
+
+type hostConns struct {
+	// idle is used last in, first out, so the connections left at the
+	// front are the ones to time out
+	idle []*persistConn
+	open int
+	// released is closed and replaced whenever a connection goes idle or is
+	// closed, waking requests waiting on MaxConnsPerHost
+	released chan struct{}
+}
+
+// signal wakes anything waiting for a connection to the host
+func (h *hostConns) signal() {
+	close(h.released)
+	h.released = make(chan struct{})
+}
+
+// persistConn is a connection that can carry one request after another
+type persistConn struct {
+	key string
+	// conn is the connection as dialled, which deadlines are set on, and rw
+	// is what is read and written, which differs for https
+	conn net.Conn
+	rw   net.Conn
+	br   *bufio.Reader
+	// reused is set when the connection came from the pool
+	reused bool
+	timer  *time.Timer
+}
+
+// Stats returns the pool's counters
+func (c *Client) Stats() PoolStats {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	return c.pool.stats
+}
+
+// CloseIdleConnections closes every connection waiting in the pool, leaving
+// those in use alone
+func (c *Client) CloseIdleConnections() {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	for _, h := range c.pool.hosts {
+		for _, pc := range h.idle {
+			pc.timer.Stop()
+			c.closeLocked(h, pc)
+		}
+		c.pool.stats.Idle -= len(h.idle)
+		h.idle = nil
+	}
+}
+
+// getConn takes an idle connection for the address if one is still open, or
+// dials a new one, waiting while MaxConnsPerHost are already open
+func (c *Client) getConn(ctx context.Context, scheme, addr string) (*persistConn, error) {
+	key := scheme + "://" + addr
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.pool.mu.Lock()
+		h := c.host(key)
+		if n := len(h.idle); n > 0 {
+			pc := h.idle[n-1]
+			h.idle = h.idle[:n-1]
+			c.pool.stats.Idle--
+			pc.timer.Stop()
+			c.pool.mu.Unlock()
+			if pc.alive() {
+				c.pool.mu.Lock()
+				c.pool.stats.Reuses++
+				c.pool.mu.Unlock()
+				pc.reused = true
+				return pc, nil
+			}
+			c.pool.mu.Lock()
+			c.pool.stats.Stale++
+			c.closeLocked(h, pc)
+			c.pool.mu.Unlock()
+			continue
+		}
+		if c.MaxConnsPerHost <= 0 || h.open < c.MaxConnsPerHost {
+			h.open++
+			c.pool.stats.Open++
+			c.pool.stats.Dials++
+			c.pool.mu.Unlock()
+			pc, err := c.dialConn(ctx, key, scheme, addr)
+			if err != nil {
+				c.pool.mu.Lock()
+				h.open--
+				c.pool.stats.Open--
+				h.signal()
+				c.pool.mu.Unlock()
+			}
+			return pc, err
+		}
+		released := h.released
+		c.pool.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) dialConn(ctx context.Context, key, scheme, addr string) (*persistConn, error) {
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to %s: %w", addr, err)
+	}
+	rw := conn
+	if scheme == "https" {
+		if rw, err = c.handshake(ctx, conn, addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &persistConn{
+		key:  key,
+		conn: conn,
+		rw:   rw,
+		br:   bufio.NewReaderSize(rw, max(MaxResponseLineSize, request.MaxChunkLineSize)),
+	}, nil
+}
+
+// release hands a connection back once its response is done with, keeping
+// it for the next request if it can carry one and the pool has room
+func (c *Client) release(pc *persistConn, reusable bool) {
+	pc.conn.SetDeadline(time.Time{})
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	h := c.host(pc.key)
+	maxIdle := orDefault(c.MaxIdleConns, DefaultMaxIdleConns)
+	maxIdlePerHost := orDefault(c.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost)
+	if !reusable || c.DisableKeepAlives || len(h.idle) >= maxIdlePerHost || c.pool.stats.Idle >= maxIdle {
+		c.closeLocked(h, pc)
+		return
+	}
+	h.idle = append(h.idle, pc)
+	c.pool.stats.Idle++
+	pc.timer = time.AfterFunc(orDefault(c.IdleTimeout, DefaultIdleTimeout), func() {
+		c.expire(pc)
+	})
+	h.signal()
+}
+
+// expire closes a connection that has been idle for IdleTimeout, unless it
+// has been taken from the pool since
+func (c *Client) expire(pc *persistConn) {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+	h := c.host(pc.key)
+	i := slices.Index(h.idle, pc)
+	if i == -1 {
+		return
+	}
+	h.idle = slices.Delete(h.idle, i, i+1)
+	c.pool.stats.Idle--
+	c.closeLocked(h, pc)
+}
+
+func (c *Client) closeLocked(h *hostConns, pc *persistConn) {
+	pc.rw.Close()
+	h.open--
+	c.pool.stats.Open--
+	h.signal()
+}
+
+func (c *Client) host(key string) *hostConns {
+	if c.pool.hosts == nil {
+		c.pool.hosts = make(map[string]*hostConns)
+	}
+	h, ok := c.pool.hosts[key]
+	if !ok {
+		h = &hostConns{released: make(chan struct{})}
+		c.pool.hosts[key] = h
+	}
+	return h
+}
+
+// alive checks an idle connection hasn't been closed by the server. Nothing
+// should arrive between responses, so a read that doesn't time out at once
+// means the connection has either been closed or can't be trusted.
+func (pc *persistConn) alive() bool {
+	if pc.br.Buffered() > 0 {
+		return false
+	}
+	pc.rw.SetReadDeadline(time.Unix(1, 0))
+	_, err := pc.br.Peek(1)
+	pc.rw.SetReadDeadline(time.Time{})
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// orDefault returns the limit, or def if it is left at zero. A negative limit
+// is returned as it is.
+func orDefault[T int | time.Duration](limit, def T) T {
+	if limit == 0 {
+		return def
+	}
+	return limit
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keepAliveClient returns a client whose connections are each served by
+// serveKeepAlive, answering at most perConn requests unless it is 0. Each
+// connection's serve loop closes done once it has finished.
+func keepAliveClient(perConn int, done chan<- struct{}) *Client {
+	return &Client{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, conn := net.Pipe()
+			go func() {
+				serveKeepAlive(conn, perConn)
+				if done != nil {
+					done <- struct{}{}
+				}
+			}()
+			return client, nil
+		},
+	}
+}
+
+// serveKeepAlive answers requests with their path until the connection
+// closes. A request for /close is answered with Connection: close, and one
+// for /drop is read and then left without an answer.
+func serveKeepAlive(conn net.Conn, perConn int) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for i := 0; perConn == 0 || i < perConn; i++ {
+		req, err := request.RequestFromReader(br)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		switch req.Target.Path {
+		case "/drop":
+			return
+		case "/close":
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 6\r\n\r\n/close")
+			return
+		case "/big":
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", maxDrainSize*2, strings.Repeat("a", maxDrainSize*2))
+		default:
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(req.Target.Path), req.Target.Path)
+		}
+	}
+}
+
+func get(t *testing.T, c *Client, path string) string {
+	t.Helper()
+	resp, err := c.Get(context.Background(), "http://example.com"+path)
+	require.NoError(t, err)
+	return readBody(t, resp)
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	// Test: Connection reused for requests one after another
+	c := keepAliveClient(0, nil)
+	assert.Equal(t, "/a", get(t, c, "/a"))
+	assert.Equal(t, "/b", get(t, c, "/b"))
+	assert.Equal(t, "/c", get(t, c, "/c"))
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Dials)
+	assert.Equal(t, int64(2), stats.Reuses)
+	assert.Equal(t, 1, stats.Open)
+	assert.Equal(t, 1, stats.Idle)
+
+	// Test: Unread body is drained so the connection can be reused
+	resp, err := c.Get(ctx, "http://example.com/unread")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "/d", get(t, c, "/d"))
+	assert.Equal(t, int64(1), c.Stats().Dials)
+
+	// Test: Body too large to drain closes the connection
+	resp, err = c.Get(ctx, "http://example.com/big")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 0, c.Stats().Open)
+
+	// Test: Connections to other hosts are kept apart
+	c = keepAliveClient(0, nil)
+	get(t, c, "/a")
+	resp, err = c.Get(ctx, "http://other.example.com/a")
+	require.NoError(t, err)
+	readBody(t, resp)
+	assert.Equal(t, int64(2), c.Stats().Dials)
+	assert.Equal(t, 2, c.Stats().Idle)
+
+	// Test: CloseIdleConnections empties the pool
+	c.CloseIdleConnections()
+	assert.Equal(t, PoolStats{Dials: 2}, c.Stats())
+
+	// Test: Connection: close isn't reused
+	c = keepAliveClient(0, nil)
+	assert.Equal(t, "/close", get(t, c, "/close"))
+	get(t, c, "/a")
+	assert.Equal(t, int64(2), c.Stats().Dials)
+
+	// Test: Keep-alives turned off
+	c = keepAliveClient(0, nil)
+	c.DisableKeepAlives = true
+	get(t, c, "/a")
+	get(t, c, "/b")
+	assert.Equal(t, int64(2), c.Stats().Dials)
+	assert.Equal(t, 0, c.Stats().Open)
+}
+
+func TestPoolStale(t *testing.T) {
+	ctx := context.Background()
+
+	// Test: Idle connection closed by the server is found before reuse
+	done := make(chan struct{}, 2)
+	c := keepAliveClient(1, done)
+	get(t, c, "/a")
+	<-done
+	assert.Equal(t, "/b", get(t, c, "/b"))
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Stale)
+	assert.Equal(t, int64(2), stats.Dials)
+	assert.Equal(t, int64(0), stats.Retries)
+
+	// Test: Idempotent request retried when a reused connection is closed
+	// before the response
+	c = keepAliveClient(0, nil)
+	get(t, c, "/a")
+	resp, err := c.Get(ctx, "http://example.com/drop")
+	require.Error(t, err, "a new connection is dropped as well")
+	assert.Nil(t, resp)
+	stats = c.Stats()
+	assert.Equal(t, int64(1), stats.Retries)
+	assert.Equal(t, int64(2), stats.Dials)
+
+	// Test: Request with a body isn't retried
+	c = keepAliveClient(0, nil)
+	get(t, c, "/a")
+	req, err := request.NewRequest("POST", "http://example.com/drop", strings.NewReader("data"))
+	require.NoError(t, err)
+	_, err = c.Do(ctx, req)
+	require.Error(t, err)
+	assert.Equal(t, int64(0), c.Stats().Retries)
+	assert.Equal(t, int64(1), c.Stats().Dials)
+}
+
+func TestPoolLimits(t *testing.T) {
+	ctx := context.Background()
+
+	// Test: Idle connections past MaxIdleConnsPerHost are closed
+	c := keepAliveClient(0, nil)
+	c.MaxIdleConnsPerHost = 1
+	first, err := c.Get(ctx, "http://example.com/a")
+	require.NoError(t, err)
+	second, err := c.Get(ctx, "http://example.com/b")
+	require.NoError(t, err)
+	readBody(t, first)
+	readBody(t, second)
+	stats := c.Stats()
+	assert.Equal(t, int64(2), stats.Dials)
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, 1, stats.Open)
+
+	// Test: No idle connections kept at all
+	c = keepAliveClient(0, nil)
+	c.MaxIdleConns = -1
+	get(t, c, "/a")
+	assert.Equal(t, 0, c.Stats().Open)
+
+	// Test: Requests wait for a connection under MaxConnsPerHost
+	c = keepAliveClient(0, nil)
+	c.MaxConnsPerHost = 1
+	first, err = c.Get(ctx, "http://example.com/a")
+	require.NoError(t, err)
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	_, err = c.Get(waitCtx, "http://example.com/b")
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	time.AfterFunc(20*time.Millisecond, func() { first.Body.Close() })
+	assert.Equal(t, "/b", get(t, c, "/b"))
+	assert.Equal(t, int64(1), c.Stats().Dials)
+
+	// Test: Idle connections are closed after IdleTimeout
+	c = keepAliveClient(0, nil)
+	c.IdleTimeout = 10 * time.Millisecond
+	get(t, c, "/a")
+	assert.Eventually(t, func() bool {
+		return c.Stats().Open == 0
+	}, time.Second, 5*time.Millisecond)
+	get(t, c, "/b")
+	assert.Equal(t, int64(2), c.Stats().Dials)
+	assert.Equal(t, int64(0), c.Stats().Reuses)
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+)
+
+var (
+	// MaxResponseLineSize bounds the status line and each header line
+	MaxResponseLineSize = 8 << 10
+	// MaxResponseHeaderBytes bounds all of the header lines together
+	MaxResponseHeaderBytes = 64 << 10
+)
+
+var (
+	ErrMalformedStatusLine = errors.New("malformed status line")
+	ErrResponseLineTooLong = errors.New("response line too long")
+	ErrHeadersTooLarge     = errors.New("response headers too large")
+	ErrBodyTruncated       = errors.New("response body is shorter than content-length")
+)
+
+// Response is a response read from a server
+type Response struct {
+	StatusCode response.StatusCode
+	Reason     string
+	Headers    *headers.Headers
+	// Body streams the response body and must be closed. It is never nil.
+	Body io.ReadCloser
+	// ContentLength is the length of the body as sent, or -1 if it is
+	// chunked or runs until the connection closes
+	ContentLength int64
+	// Trailers holds the trailer fields of a chunked body. It is only filled
+	// in once the body has been read to the end.
+	Trailers *headers.Headers
+
+	// closeConn is set when the connection can't carry another request
+	closeConn bool
+}
+
+// ResponseFromReader reads a response to a GET request from reader. Interim
+// 1xx responses are skipped, and the body is left on the reader to be
+// streamed through Body.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return readResponse(bufio.NewReaderSize(reader, MaxResponseLineSize), "GET")
+}
+
+// readResponse reads the response to a request made with method, which is
+// needed to tell whether it has a body
+func readResponse(br *bufio.Reader, method string) (*Response, error) {
+	for {
+		resp, err := readHead(br)
+		if err != nil {
+			return nil, err
+		}
+		// 101 is the last response on the connection, while the other 1xx
+		// responses come before the final one (RFC 9110 section 15.2)
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != response.SwitchingProtocols {
+			continue
+		}
+		if err := resp.setupBody(br, method); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// readHead reads the status line and headers
+func readHead(br *bufio.Reader) (*Response, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		Body:     request.NoBody,
+	}
+	if err := resp.parseStatusLine(string(line)); err != nil {
+		return nil, err
+	}
+	total := 0
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return resp, nil
+		}
+		total += len(line)
+		if total > MaxResponseHeaderBytes {
+			return nil, fmt.Errorf("%w: over %d bytes", ErrHeadersTooLarge, MaxResponseHeaderBytes)
+		}
+		if _, _, err := resp.Headers.Parse(append(line, '\r', '\n')); err != nil {
+			return nil, fmt.Errorf("error while parsing response headers: %w", err)
+		}
+	}
+}
+
+// parseStatusLine parses "HTTP/1.x code reason" (RFC 9112 section 4), where
+// the reason may be empty
+func (r *Response) parseStatusLine(line string) error {
+	version, rest, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(version, "HTTP/1.") || len(version) != len("HTTP/1.1") {
+		return fmt.Errorf("%w: %q", ErrMalformedStatusLine, line)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(code) != 3 || !isDigits(code) || code[0] == '0' {
+		return fmt.Errorf("%w: bad status code in %q", ErrMalformedStatusLine, line)
+	}
+	n, _ := strconv.Atoi(code)
+	r.StatusCode = response.StatusCode(n)
+	r.Reason = reason
+	// HTTP/1.0 connections only persist when asked to (RFC 9112 section 9.3)
+	if version == "HTTP/1.0" {
+		r.closeConn = true
+	}
+	return nil
+}
+
+// setupBody works out how the body is framed (RFC 9112 section 6.3) and
+// points Body at it
+func (r *Response) setupBody(br *bufio.Reader, method string) error {
+	if hasToken(r.Headers, "Connection", "close") || r.StatusCode == response.SwitchingProtocols {
+		r.closeConn = true
+	} else if hasToken(r.Headers, "Connection", "keep-alive") {
+		r.closeConn = false
+	}
+	if method == "HEAD" || !response.BodyAllowed(r.StatusCode) {
+		return nil
+	}
+	if te, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		codings := strings.Split(te, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.ContentLength = -1
+			r.Body = request.NewChunkedReader(br, 0, func(h *headers.Headers) { r.Trailers = h })
+			return nil
+		}
+		// Without chunked last the body runs until the connection closes,
+		// and any Content-Length is ignored
+		r.ContentLength = -1
+		r.Body = io.NopCloser(br)
+		r.closeConn = true
+		return nil
+	}
+	values := r.Headers.Values("Content-Length")
+	if len(values) == 0 {
+		r.ContentLength = -1
+		r.Body = io.NopCloser(br)
+		r.closeConn = true
+		return nil
+	}
+	length, err := parseContentLength(values)
+	if err != nil {
+		return err
+	}
+	r.ContentLength = length
+	if length > 0 {
+		r.Body = &lengthBody{r: br, remaining: length}
+	}
+	return nil
+}
+
+// parseContentLength allows the field to be repeated, or hold a list, only
+// if every value is the same
+func parseContentLength(values []string) (int64, error) {
+	var first string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if !isDigits(part) || (first != "" && part != first) {
+				return 0, fmt.Errorf("%w: %q", request.ErrInvalidContentLength, strings.Join(values, ", "))
+			}
+			first = part
+		}
+	}
+	length, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", request.ErrInvalidContentLength, first)
+	}
+	return length, nil
+}
+
+// lengthBody reads exactly Content-Length bytes
+type lengthBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (b *lengthBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && b.remaining > 0 {
+		return n, fmt.Errorf("%w: %d bytes missing", ErrBodyTruncated, b.remaining)
+	}
+	return n, err
+}
+
+func (b *lengthBody) Close() error {
+	return nil
+}
+
+// readLine returns the next line without its line ending. A bare LF is
+// accepted as RFC 9112 section 2.2 allows.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrResponseLineTooLong, br.Size())
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("connection closed before the end of the headers: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return bytes.Clone(line), nil
+}
+
+// hasToken reports whether the list in the named field holds token
+func hasToken(h *headers.Headers, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
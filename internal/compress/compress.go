@@ -0,0 +1,169 @@
+// Package compress negotiates and applies response content-coding
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/2bitburrito/http-implementation/internal/server"
+)
+
+// DefaultMinSize is the smallest body, in bytes, worth compressing
+const DefaultMinSize = 128
+
+// supportedEncodings is in order of preference when q-values are tied
+var supportedEncodings = []string{"gzip", "deflate"}
+
+var compressibleTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Middleware compresses responses from next when the client accepts it.
+// Bodies with a known length smaller than minSize are sent as is.
+func Middleware(minSize int, next server.Handler) server.Handler {
+	return func(w response.Writer, req *request.Request) {
+		encoding := ""
+		if req != nil {
+			accept, _ := req.Headers.Get("Accept-Encoding")
+			encoding = Negotiate(accept)
+		}
+		w.AddBodyFilter(filter(encoding, minSize))
+		next(w, req)
+	}
+}
+
+func filter(encoding string, minSize int) response.BodyFilter {
+	return func(status response.StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser {
+		contentType, _ := h.Get("Content-Type")
+		if !Compressible(contentType) {
+			return nil
+		}
+		response.AddVary(h, "Accept-Encoding")
+
+		if encoding == "" || status == response.PartialContent || !response.BodyAllowed(status) {
+			return nil
+		}
+		if _, ok := h.Get("Content-Range"); ok {
+			return nil
+		}
+		if _, ok := h.Get("Content-Encoding"); ok {
+			return nil
+		}
+		if cl, ok := h.Get("Content-Length"); ok {
+			n, err := strconv.Atoi(cl)
+			if err != nil || n < minSize {
+				return nil
+			}
+		}
+
+		// The compressed length isn't known up front so fall back to chunking
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", encoding)
+		h.Set("Transfer-Encoding", "chunked")
+		return newEncoder(encoding, &response.ChunkedWriter{Dst: dst})
+	}
+}
+
+type encoder struct {
+	io.WriteCloser
+	dst io.WriteCloser
+}
+
+func (e *encoder) Close() error {
+	if err := e.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return e.dst.Close()
+}
+
+func newEncoder(encoding string, dst io.WriteCloser) io.WriteCloser {
+	switch encoding {
+	case "gzip":
+		return &encoder{WriteCloser: gzip.NewWriter(dst), dst: dst}
+	case "deflate":
+		// HTTP's "deflate" coding is the zlib format
+		return &encoder{WriteCloser: zlib.NewWriter(dst), dst: dst}
+	default:
+		return nil
+	}
+}
+
+// Negotiate picks a supported content-coding from an Accept-Encoding value,
+// honouring q-values. An empty string means the body should be left as is.
+func Negotiate(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, q := parseQuality(part)
+		if coding == "" {
+			continue
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, enc := range supportedEncodings {
+		q, ok := weights[enc]
+		if !ok {
+			if wildcard < 0 {
+				continue
+			}
+			q = wildcard
+		}
+		if q > bestQ {
+			best = enc
+			bestQ = q
+		}
+	}
+	return best
+}
+
+func parseQuality(part string) (string, float64) {
+	coding, params, _ := strings.Cut(part, ";")
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0
+		}
+		q = parsed
+	}
+	return coding, q
+}
+
+// Compressible reports whether a media type benefits from compression.
+// Already compressed formats such as images, video and archives do not.
+func Compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		slices.Contains(compressibleTypes, mediaType)
+}
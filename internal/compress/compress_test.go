@@ -0,0 +1,154 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	// Test: No header
+	assert.Equal(t, "", Negotiate(""))
+
+	// Test: Plain gzip
+	assert.Equal(t, "gzip", Negotiate("gzip"))
+
+	// Test: Preferred by q-value
+	assert.Equal(t, "deflate", Negotiate("gzip;q=0.5, deflate"))
+
+	// Test: Ties go to gzip
+	assert.Equal(t, "gzip", Negotiate("deflate, gzip"))
+
+	// Test: Wildcard
+	assert.Equal(t, "gzip", Negotiate("br, *;q=0.1"))
+
+	// Test: Explicitly refused
+	assert.Equal(t, "deflate", Negotiate("gzip;q=0, deflate;q=0.2"))
+	assert.Equal(t, "", Negotiate("gzip;q=0, *;q=0"))
+
+	// Test: Unsupported only
+	assert.Equal(t, "", Negotiate("br, identity"))
+
+	// Test: Malformed q-value is ignored
+	assert.Equal(t, "deflate", Negotiate("gzip;q=abc, deflate"))
+}
+
+func TestCompressible(t *testing.T) {
+	assert.True(t, Compressible("text/html"))
+	assert.True(t, Compressible("application/json; charset=utf-8"))
+	assert.True(t, Compressible("application/problem+json"))
+	assert.False(t, Compressible("video/mp4"))
+	assert.False(t, Compressible("application/gzip"))
+	assert.False(t, Compressible(""))
+}
+
+func TestFilter(t *testing.T) {
+	body := strings.Repeat("compress me please ", 20)
+
+	// Test: Large html body is compressed and chunked
+	client, conn := net.Pipe()
+	go func() {
+		defer conn.Close()
+		w := &response.ConnWriter{Conn: conn}
+		w.AddBodyFilter(filter("gzip", DefaultMinSize))
+		w.WriteStatusLine(response.OK)
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", "text/html")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+		w.Close()
+	}()
+	h, raw := readResponse(t, client)
+	assert.Equal(t, "gzip", h["content-encoding"])
+	assert.Equal(t, "chunked", h["transfer-encoding"])
+	assert.Equal(t, "Accept-Encoding", h["vary"])
+	_, ok := h["content-length"]
+	assert.False(t, ok)
+	gz, err := gzip.NewReader(strings.NewReader(dechunk(t, raw)))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	// Test: Small body is left alone
+	client, conn = net.Pipe()
+	go func() {
+		defer conn.Close()
+		w := &response.ConnWriter{Conn: conn}
+		w.AddBodyFilter(filter("gzip", DefaultMinSize))
+		w.WriteStatusLine(response.OK)
+		h := response.GetDefaultHeaders(2)
+		h.Set("Content-Type", "text/plain")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("hi"))
+		w.Close()
+	}()
+	h, raw = readResponse(t, client)
+	assert.Equal(t, "2", h["content-length"])
+	assert.Equal(t, "Accept-Encoding", h["vary"])
+	assert.Equal(t, "hi", raw)
+
+	// Test: Already compressed type is left alone
+	client, conn = net.Pipe()
+	go func() {
+		defer conn.Close()
+		w := &response.ConnWriter{Conn: conn}
+		w.AddBodyFilter(filter("gzip", DefaultMinSize))
+		w.WriteStatusLine(response.OK)
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("Content-Type", "video/mp4")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+		w.Close()
+	}()
+	h, raw = readResponse(t, client)
+	assert.Equal(t, strconv.Itoa(len(body)), h["content-length"])
+	_, ok = h["vary"]
+	assert.False(t, ok)
+	assert.Equal(t, body, raw)
+}
+
+// readResponse reads a whole response, returning lowercased headers and the raw body
+func readResponse(t *testing.T, conn net.Conn) (map[string]string, string) {
+	t.Helper()
+	reader := bufio.NewReader(conn)
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	h := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		k, v, _ := strings.Cut(strings.TrimSpace(line), ":")
+		h[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return h, string(rest)
+}
+
+func dechunk(t *testing.T, raw string) string {
+	t.Helper()
+	out := strings.Builder{}
+	for {
+		sizeLine, rest, found := strings.Cut(raw, "\r\n")
+		require.True(t, found)
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			return out.String()
+		}
+		out.WriteString(rest[:size])
+		raw = rest[size+2:]
+	}
+}
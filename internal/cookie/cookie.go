@@ -0,0 +1,205 @@
+// Package cookie parses and serialises HTTP cookies (RFC 6265)
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge of 0 leaves the attribute off, a negative MaxAge deletes the
+	// cookie straight away ("Max-Age=0")
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// String serialises the cookie as a Set-Cookie header value
+func (c *Cookie) String() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%s=%s", c.Name, quoteValue(c.Value))
+	if c.Path != "" {
+		fmt.Fprintf(&b, "; Path=%s", c.Path)
+	}
+	if c.Domain != "" {
+		fmt.Fprintf(&b, "; Domain=%s", strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		fmt.Fprintf(&b, "; Expires=%s", c.Expires.UTC().Format(headers.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		fmt.Fprintf(&b, "; Max-Age=%d", c.MaxAge)
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	// Browsers reject SameSite=None and Partitioned cookies without Secure
+	if c.Secure || c.SameSite == SameSiteNone || c.Partitioned {
+		b.WriteString("; Secure")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Valid checks the name, value and attributes can be sent in a Set-Cookie
+func (c *Cookie) Valid() error {
+	if !validName(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+	for _, ch := range []byte(c.Value) {
+		if !validValueByte(ch) && ch != ' ' && ch != ',' {
+			return fmt.Errorf("invalid byte %q in value of cookie %q", ch, c.Name)
+		}
+	}
+	for _, attr := range []string{c.Path, c.Domain} {
+		if strings.ContainsAny(attr, ";\r\n") {
+			return fmt.Errorf("invalid attribute %q for cookie %q", attr, c.Name)
+		}
+	}
+	return nil
+}
+
+// Parse parses a request's Cookie header value. Malformed pairs are skipped.
+func Parse(header string) []*Cookie {
+	cookies := []*Cookie{}
+	for _, pair := range strings.Split(header, ";") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !validName(name) {
+			continue
+		}
+		value, ok := unquoteValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses a single Set-Cookie header value, as received by a client
+func ParseSetCookie(header string) (*Cookie, error) {
+	parts := strings.Split(header, ";")
+	name, value, found := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !found || !validName(name) {
+		return nil, fmt.Errorf("invalid set-cookie pair: %q", parts[0])
+	}
+	value, ok := unquoteValue(value)
+	if !ok {
+		return nil, fmt.Errorf("invalid value for cookie %q", name)
+	}
+	c := &Cookie{Name: name, Value: value}
+	for _, part := range parts[1:] {
+		attr, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(attr)) {
+		case "path":
+			c.Path = val
+		case "domain":
+			c.Domain = strings.TrimPrefix(val, ".")
+		case "expires":
+			if t, err := time.Parse(headers.TimeFormat, val); err == nil {
+				c.Expires = t
+			}
+		case "max-age":
+			if n, err := strconv.Atoi(val); err == nil {
+				c.MaxAge = n
+				if n <= 0 {
+					c.MaxAge = -1
+				}
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+var separators = "()<>@,;:\\\"/[]?={} \t"
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(separators, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validValueByte checks for a cookie-octet
+func validValueByte(c byte) bool {
+	return c == 0x21 ||
+		(c >= 0x23 && c <= 0x2b) ||
+		(c >= 0x2d && c <= 0x3a) ||
+		(c >= 0x3c && c <= 0x5b) ||
+		(c >= 0x5d && c <= 0x7e)
+}
+
+func unquoteValue(value string) (string, bool) {
+	quoted := len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"'
+	if quoted {
+		value = value[1 : len(value)-1]
+	}
+	for _, c := range []byte(value) {
+		if !validValueByte(c) && !(quoted && (c == ' ' || c == ',')) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+func quoteValue(value string) string {
+	// Spaces and commas aren't cookie-octets but are widely accepted when quoted
+	if strings.ContainsAny(value, " ,") {
+		return `"` + value + `"`
+	}
+	return value
+}
@@ -0,0 +1,92 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Multiple cookies
+	cookies := Parse("session=abc123; theme=dark")
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+
+	// Test: Quoted value
+	cookies = Parse(`greeting="hello, world"`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "hello, world", cookies[0].Value)
+
+	// Test: Empty value
+	cookies = Parse("empty=")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "", cookies[0].Value)
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse("noequals; bad name=1; ok=yes; bad=\"un\"quoted\"")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+}
+
+func TestString(t *testing.T) {
+	// Test: Plain cookie
+	c := &Cookie{Name: "id", Value: "42"}
+	assert.Equal(t, "id=42", c.String())
+
+	// Test: All attributes
+	c = &Cookie{
+		Name:        "session",
+		Value:       "abc",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		HttpOnly:    true,
+		SameSite:    SameSiteStrict,
+		Partitioned: true,
+	}
+	assert.Equal(t, "session=abc; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 03:04:05 GMT; "+
+		"Max-Age=3600; HttpOnly; Secure; SameSite=Strict; Partitioned", c.String())
+
+	// Test: Deleting a cookie
+	c = &Cookie{Name: "id", MaxAge: -1}
+	assert.Equal(t, "id=; Max-Age=0", c.String())
+
+	// Test: SameSite=None forces Secure
+	c = &Cookie{Name: "id", Value: "1", SameSite: SameSiteNone}
+	assert.Equal(t, "id=1; Secure; SameSite=None", c.String())
+
+	// Test: Values with spaces are quoted
+	c = &Cookie{Name: "greeting", Value: "hello world"}
+	assert.Equal(t, `greeting="hello world"`, c.String())
+}
+
+func TestValid(t *testing.T) {
+	assert.NoError(t, (&Cookie{Name: "ok", Value: "fine"}).Valid())
+	assert.Error(t, (&Cookie{Name: "", Value: "x"}).Valid())
+	assert.Error(t, (&Cookie{Name: "semi;colon", Value: "x"}).Valid())
+	assert.Error(t, (&Cookie{Name: "inject", Value: "x\r\nX-Evil: 1"}).Valid())
+	assert.Error(t, (&Cookie{Name: "path", Value: "x", Path: "/; Domain=evil.com"}).Valid())
+}
+
+func TestParseSetCookie(t *testing.T) {
+	c, err := ParseSetCookie("session=abc; Path=/; Domain=.example.com; Max-Age=60; Secure; HttpOnly; SameSite=Lax; Partitioned")
+	require.NoError(t, err)
+	assert.Equal(t, "session", c.Name)
+	assert.Equal(t, "abc", c.Value)
+	assert.Equal(t, "/", c.Path)
+	assert.Equal(t, "example.com", c.Domain)
+	assert.Equal(t, 60, c.MaxAge)
+	assert.True(t, c.Secure)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, SameSiteLax, c.SameSite)
+	assert.True(t, c.Partitioned)
+
+	_, err = ParseSetCookie("no-pair")
+	require.Error(t, err)
+}
@@ -0,0 +1,170 @@
+// Package cors implements Cross-Origin Resource Sharing for server handlers
+package cors
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/2bitburrito/http-implementation/internal/server"
+)
+
+var defaultMethods = []string{"GET", "POST"}
+
+type Options struct {
+	// AllowedOrigins lists origins that may make cross-origin requests. Entries
+	// may contain a single "*" wildcard for subdomains ("https://*.example.com"),
+	// and "*" on its own allows every origin.
+	AllowedOrigins []string
+	// AllowOriginFunc, if set, is consulted for origins not in AllowedOrigins
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods defaults to GET and POST
+	AllowedMethods []string
+	// AllowedHeaders lists request headers a preflight may ask for. "*"
+	// allows whatever headers are requested.
+	AllowedHeaders []string
+	ExposedHeaders []string
+	// AllowCredentials lets the origins allowed by AllowedOrigins patterns or
+	// AllowOriginFunc send credentials. It never applies to origins allowed
+	// only by a bare "*", which the CORS protocol forbids.
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Middleware answers CORS preflight requests itself and adds the CORS
+// response headers to everything else handled by next
+func Middleware(opts Options, next server.Handler) server.Handler {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultMethods
+	}
+	return func(w response.Writer, req *request.Request) {
+		origin, hasOrigin := req.Headers.Get("Origin")
+		if !hasOrigin {
+			next(w, req)
+			return
+		}
+		requestMethod, isPreflight := req.Headers.Get("Access-Control-Request-Method")
+		if req.RequestLine.Method == "OPTIONS" && isPreflight {
+			opts.preflight(w, req, origin, requestMethod)
+			return
+		}
+		w.AddBodyFilter(func(_ response.StatusCode, h *headers.Headers, _ io.Writer) io.WriteCloser {
+			response.AddVary(h, "Origin")
+			if !opts.originAllowed(origin) {
+				return nil
+			}
+			opts.setAllowOrigin(h, origin)
+			if len(opts.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+			}
+			return nil
+		})
+		next(w, req)
+	}
+}
+
+func (o *Options) preflight(w response.Writer, req *request.Request, origin, method string) {
+	h := response.GetDefaultHeaders(0)
+	response.AddVary(h, "Origin")
+	response.AddVary(h, "Access-Control-Request-Method")
+	response.AddVary(h, "Access-Control-Request-Headers")
+
+	requested := []string{}
+	if list, ok := req.Headers.Get("Access-Control-Request-Headers"); ok {
+		for _, field := range strings.Split(list, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				requested = append(requested, field)
+			}
+		}
+	}
+
+	// Leaving the CORS headers off is how a preflight is refused
+	if o.originAllowed(origin) &&
+		slices.Contains(o.AllowedMethods, method) &&
+		o.headersAllowed(requested) {
+		o.setAllowOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", strings.Join(o.AllowedMethods, ", "))
+		if len(requested) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+		if o.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge/time.Second)))
+		}
+	}
+	w.WriteStatusLine(response.NoContent)
+	w.WriteHeaders(h)
+}
+
+func (o *Options) setAllowOrigin(h *headers.Headers, origin string) {
+	credentials := o.AllowCredentials && o.listed(origin)
+	if slices.Contains(o.AllowedOrigins, "*") && !credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (o *Options) originAllowed(origin string) bool {
+	// Opaque origins can only be allowed explicitly
+	if slices.Contains(o.AllowedOrigins, "*") && !strings.EqualFold(origin, "null") {
+		return true
+	}
+	return o.listed(origin)
+}
+
+// listed reports whether origin is allowed other than by a bare "*"
+func (o *Options) listed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if origin == "null" {
+		return slices.Contains(o.AllowedOrigins, "null")
+	}
+	for _, allowed := range o.AllowedOrigins {
+		if allowed != "*" && matchOrigin(strings.ToLower(allowed), origin) {
+			return true
+		}
+	}
+	return o.AllowOriginFunc != nil && o.AllowOriginFunc(origin)
+}
+
+// matchOrigin compares an origin against a pattern which may contain a
+// single "*" standing in for one or more subdomain labels
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) ||
+		!strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	middle := origin[len(prefix) : len(origin)-len(suffix)]
+	for _, c := range middle {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Options) headersAllowed(requested []string) bool {
+	if slices.Contains(o.AllowedHeaders, "*") {
+		return true
+	}
+	for _, field := range requested {
+		if !slices.ContainsFunc(o.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, field)
+		}) {
+			return false
+		}
+	}
+	return true
+}
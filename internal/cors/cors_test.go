@@ -0,0 +1,158 @@
+package cors
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/2bitburrito/http-implementation/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(w response.Writer, _ *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
+
+// serve runs hdlr against a raw request, returning the status line and lowercased headers
+func serve(t *testing.T, hdlr server.Handler, raw string) (string, map[string]string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	client, conn := net.Pipe()
+	go func() {
+		defer conn.Close()
+		hdlr(&response.ConnWriter{Conn: conn}, req)
+	}()
+	reader := bufio.NewReader(client)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	h := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		k, v, _ := strings.Cut(strings.TrimSpace(line), ":")
+		h[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	io.Copy(io.Discard, reader)
+	return strings.TrimSpace(status), h
+}
+
+func TestPreflight(t *testing.T) {
+	hdlr := Middleware(Options{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}, okHandler)
+
+	// Test: Allowed preflight
+	status, h := serve(t, hdlr, "OPTIONS /thing HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://app.example.com\r\n"+
+		"Access-Control-Request-Method: PUT\r\n"+
+		"Access-Control-Request-Headers: content-type, x-token\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 204 No Content", status)
+	assert.Equal(t, "https://app.example.com", h["access-control-allow-origin"])
+	assert.Equal(t, "GET, PUT", h["access-control-allow-methods"])
+	assert.Equal(t, "content-type, x-token", h["access-control-allow-headers"])
+	assert.Equal(t, "true", h["access-control-allow-credentials"])
+	assert.Equal(t, "600", h["access-control-max-age"])
+	assert.Contains(t, h["vary"], "Origin")
+
+	// Test: Wildcard subdomain pattern
+	_, h = serve(t, hdlr, "OPTIONS /thing HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://a.b.example.org\r\n"+
+		"Access-Control-Request-Method: GET\r\n\r\n")
+	assert.Equal(t, "https://a.b.example.org", h["access-control-allow-origin"])
+
+	// Test: Disallowed origin
+	_, h = serve(t, hdlr, "OPTIONS /thing HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://evil.com\r\n"+
+		"Access-Control-Request-Method: GET\r\n\r\n")
+	_, ok := h["access-control-allow-origin"]
+	assert.False(t, ok)
+
+	// Test: Disallowed method
+	_, h = serve(t, hdlr, "OPTIONS /thing HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://app.example.com\r\n"+
+		"Access-Control-Request-Method: DELETE\r\n\r\n")
+	_, ok = h["access-control-allow-origin"]
+	assert.False(t, ok)
+
+	// Test: Disallowed header
+	_, h = serve(t, hdlr, "OPTIONS /thing HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://app.example.com\r\n"+
+		"Access-Control-Request-Method: GET\r\n"+
+		"Access-Control-Request-Headers: X-Other\r\n\r\n")
+	_, ok = h["access-control-allow-origin"]
+	assert.False(t, ok)
+}
+
+func TestActualRequest(t *testing.T) {
+	hdlr := Middleware(Options{
+		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id"},
+	}, okHandler)
+
+	// Test: Any origin
+	status, h := serve(t, hdlr, "GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://anywhere.net\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "*", h["access-control-allow-origin"])
+	assert.Equal(t, "X-Request-Id", h["access-control-expose-headers"])
+	assert.Equal(t, "Origin", h["vary"])
+
+	// Test: No Origin means no CORS headers
+	_, h = serve(t, hdlr, "GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n\r\n")
+	_, ok := h["access-control-allow-origin"]
+	assert.False(t, ok)
+
+	// Test: Credentials are never allowed for the bare wildcard
+	hdlr = Middleware(Options{AllowedOrigins: []string{"*", "https://app.example.com"}, AllowCredentials: true}, okHandler)
+	_, h = serve(t, hdlr, "GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://anywhere.net\r\n\r\n")
+	assert.Equal(t, "*", h["access-control-allow-origin"])
+	_, ok = h["access-control-allow-credentials"]
+	assert.False(t, ok)
+	_, h = serve(t, hdlr, "OPTIONS /thing HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://anywhere.net\r\n"+
+		"Access-Control-Request-Method: GET\r\n\r\n")
+	assert.Equal(t, "*", h["access-control-allow-origin"])
+	_, ok = h["access-control-allow-credentials"]
+	assert.False(t, ok)
+
+	// Test: Listed origin alongside the wildcard keeps its credentials
+	_, h = serve(t, hdlr, "GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://app.example.com\r\n\r\n")
+	assert.Equal(t, "https://app.example.com", h["access-control-allow-origin"])
+	assert.Equal(t, "true", h["access-control-allow-credentials"])
+
+	// Test: Pattern can't be tricked by a suffix
+	hdlr = Middleware(Options{AllowedOrigins: []string{"https://*.example.com"}}, okHandler)
+	_, h = serve(t, hdlr, "GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Origin: https://evil.com/.example.com\r\n\r\n")
+	_, ok = h["access-control-allow-origin"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,281 @@
+// Package form parses application/x-www-form-urlencoded and
+// multipart/form-data request bodies
+package form
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported form content type")
+	ErrMalformed              = errors.New("malformed form body")
+	ErrTooManyParts           = errors.New("too many form parts")
+	ErrFieldTooLarge          = errors.New("form field too large")
+	ErrFormTooLarge           = errors.New("form too large")
+)
+
+// Limits bounds how much of a form is accepted
+type Limits struct {
+	// MaxParts is the most fields and files a form may contain
+	MaxParts int
+	// MaxFieldSize is the largest value a non-file field may have
+	MaxFieldSize int64
+	// MaxMemory is how many bytes of fields and files are held in memory.
+	// File parts that don't fit are written to temporary files instead.
+	MaxMemory int64
+}
+
+var DefaultLimits = Limits{
+	MaxParts:     1000,
+	MaxFieldSize: 1 << 20,
+	MaxMemory:    10 << 20,
+}
+
+type Form struct {
+	Values map[string][]string
+	Files  map[string][]*FileHeader
+}
+
+func newForm() *Form {
+	return &Form{
+		Values: map[string][]string{},
+		Files:  map[string][]*FileHeader{},
+	}
+}
+
+// Get returns the first value for key
+func (f *Form) Get(key string) string {
+	if vals := f.Values[key]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// File returns the first file uploaded under key
+func (f *Form) File(key string) (*FileHeader, bool) {
+	if files := f.Files[key]; len(files) > 0 {
+		return files[0], true
+	}
+	return nil, false
+}
+
+// RemoveAll deletes any temporary files the form's uploads were spilled to
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, fh := range files {
+			if fh.tmpPath == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileHeader describes an uploaded file. The content is either held in
+// memory or in a temporary file, depending on its size.
+type FileHeader struct {
+	// Filename is the name the client gave, without any directories
+	Filename    string
+	ContentType string
+	Headers     *headers.Headers
+	Size        int64
+
+	content []byte
+	tmpPath string
+}
+
+// File is an open uploaded file
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpPath == "" {
+		return memoryFile{bytes.NewReader(fh.content)}, nil
+	}
+	return os.Open(fh.tmpPath)
+}
+
+// Parse parses the request body as a form according to its Content-Type.
+// Query parameters are not included, they are in req.Target.Query.
+func Parse(req *request.Request, limits Limits) (*Form, error) {
+	contentType, ok := req.Headers.Get("Content-Type")
+	if !ok {
+		return nil, fmt.Errorf("%w: no content type", ErrUnsupportedContentType)
+	}
+	mediaType, params, err := headers.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return ParseURLEncoded(req.Body, limits)
+	case "multipart/form-data":
+		boundary := params["boundary"]
+		if boundary == "" {
+			return nil, fmt.Errorf("%w: no multipart boundary", ErrMalformed)
+		}
+		return ParseMultipart(req.Body, boundary, limits)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, mediaType)
+	}
+}
+
+// ParseURLEncoded reads an application/x-www-form-urlencoded body
+func ParseURLEncoded(r io.Reader, limits Limits) (*Form, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limits.MaxMemory+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limits.MaxMemory {
+		return nil, fmt.Errorf("%w: body is over %d bytes", ErrFormTooLarge, limits.MaxMemory)
+	}
+	if parts := bytes.Count(body, []byte("&")) + 1; parts > limits.MaxParts {
+		return nil, fmt.Errorf("%w: %d fields", ErrTooManyParts, parts)
+	}
+	values, err := request.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	f := newForm()
+	for key, vals := range values {
+		for _, val := range vals {
+			if int64(len(val)) > limits.MaxFieldSize {
+				return nil, fmt.Errorf("%w: %q", ErrFieldTooLarge, key)
+			}
+		}
+		f.Values[key] = vals
+	}
+	return f, nil
+}
+
+// ParseMultipart reads a multipart/form-data body, keeping fields and small
+// files in memory and spilling larger files to temporary files. Call
+// RemoveAll on the form once the files are no longer needed.
+func ParseMultipart(r io.Reader, boundary string, limits Limits) (*Form, error) {
+	mr, err := NewMultipartReader(r, boundary)
+	if err != nil {
+		return nil, err
+	}
+	f := newForm()
+	memoryLeft := limits.MaxMemory
+	parts := 0
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return f, nil
+		}
+		if err != nil {
+			f.RemoveAll()
+			return nil, err
+		}
+		parts++
+		if parts > limits.MaxParts {
+			f.RemoveAll()
+			return nil, fmt.Errorf("%w: over %d", ErrTooManyParts, limits.MaxParts)
+		}
+		if part.FormName == "" {
+			continue
+		}
+
+		if !part.isFile {
+			val, err := io.ReadAll(io.LimitReader(part, limits.MaxFieldSize+1))
+			if err != nil {
+				f.RemoveAll()
+				return nil, err
+			}
+			if int64(len(val)) > limits.MaxFieldSize {
+				f.RemoveAll()
+				return nil, fmt.Errorf("%w: %q", ErrFieldTooLarge, part.FormName)
+			}
+			memoryLeft -= int64(len(val))
+			if memoryLeft < 0 {
+				f.RemoveAll()
+				return nil, fmt.Errorf("%w: fields are over %d bytes", ErrFormTooLarge, limits.MaxMemory)
+			}
+			f.Values[part.FormName] = append(f.Values[part.FormName], string(val))
+			continue
+		}
+
+		fh, err := readFile(part, memoryLeft)
+		if err != nil {
+			f.RemoveAll()
+			return nil, err
+		}
+		if fh.tmpPath == "" {
+			memoryLeft -= fh.Size
+		}
+		f.Files[part.FormName] = append(f.Files[part.FormName], fh)
+	}
+}
+
+// readFile keeps the part in memory if it fits in memoryLeft, otherwise
+// writes it to a temporary file
+func readFile(part *Part, memoryLeft int64) (*FileHeader, error) {
+	fh := &FileHeader{
+		Filename:    part.FileName,
+		ContentType: part.ContentType,
+		Headers:     part.Headers,
+	}
+	buf := bytes.Buffer{}
+	n, err := io.Copy(&buf, io.LimitReader(part, max(memoryLeft, 0)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n <= memoryLeft {
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return fh, nil
+	}
+
+	tmp, err := os.CreateTemp("", "form-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't store upload: %w", err)
+	}
+	size, err := io.Copy(tmp, io.MultiReader(&buf, part))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("couldn't store upload: %w", err)
+	}
+	fh.tmpPath = tmp.Name()
+	fh.Size = size
+	return fh, nil
+}
+
+// baseName strips any directories from a client supplied file name, using
+// either kind of separator
+func baseName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,184 @@
+package form
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const boundary = "xYzZY"
+
+func multipartBody(parts ...string) string {
+	body := "preamble is ignored\r\n"
+	for _, part := range parts {
+		body += "--" + boundary + "\r\n" + part + "\r\n"
+	}
+	return body + "--" + boundary + "--\r\nepilogue is ignored"
+}
+
+func readAll(t *testing.T, fh *FileHeader) string {
+	t.Helper()
+	f, err := fh.Open()
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestParseURLEncoded(t *testing.T) {
+	// Test: Fields through a request
+	req, err := request.RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+		"Content-Length: 35\r\n\r\n" +
+		"name=J%C3%B6rg+M&tag=a&tag=b&empty="))
+	require.NoError(t, err)
+	f, err := Parse(req, DefaultLimits)
+	require.NoError(t, err)
+	assert.Equal(t, "Jörg M", f.Get("name"))
+	assert.Equal(t, []string{"a", "b"}, f.Values["tag"])
+	assert.Equal(t, []string{""}, f.Values["empty"])
+
+	// Test: Bad escape
+	_, err = ParseURLEncoded(strings.NewReader("a=%zz"), DefaultLimits)
+	require.ErrorIs(t, err, ErrMalformed)
+
+	// Test: Limits
+	limits := Limits{MaxParts: 2, MaxFieldSize: 4, MaxMemory: 16}
+	_, err = ParseURLEncoded(strings.NewReader("a=1&b=2&c=3"), limits)
+	require.ErrorIs(t, err, ErrTooManyParts)
+	_, err = ParseURLEncoded(strings.NewReader("a=12345"), limits)
+	require.ErrorIs(t, err, ErrFieldTooLarge)
+	_, err = ParseURLEncoded(strings.NewReader("a=1234&b=123456789"), limits)
+	require.ErrorIs(t, err, ErrFormTooLarge)
+
+	// Test: Unsupported content type
+	req, err = request.RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 3\r\n\r\n" +
+		"a=1"))
+	require.NoError(t, err)
+	_, err = Parse(req, DefaultLimits)
+	require.ErrorIs(t, err, ErrUnsupportedContentType)
+}
+
+func TestParseMultipart(t *testing.T) {
+	body := multipartBody(
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\nHoliday\r\nphotos",
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"C:\\\\pics\\\\beach.jpg\"\r\n"+
+			"Content-Type: image/jpeg\r\n\r\n"+
+			"\xff\xd8 not really a jpeg \r\n--xYz",
+		"Content-Disposition: form-data; name=\"notes\"; filename=\"../../notes.txt\"\r\n\r\n"+
+			strings.Repeat("n", 100),
+	)
+
+	// Test: Fields and files through a request
+	req, err := request.RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: multipart/form-data; boundary=\"" + boundary + "\"\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" +
+		body))
+	require.NoError(t, err)
+	f, err := Parse(req, DefaultLimits)
+	require.NoError(t, err)
+	defer f.RemoveAll()
+	assert.Equal(t, "Holiday\r\nphotos", f.Get("title"))
+	photo, ok := f.File("photo")
+	require.True(t, ok)
+	assert.Equal(t, "beach.jpg", photo.Filename)
+	assert.Equal(t, "image/jpeg", photo.ContentType)
+	assert.Equal(t, "\xff\xd8 not really a jpeg \r\n--xYz", readAll(t, photo))
+	notes, ok := f.File("notes")
+	require.True(t, ok)
+	assert.Equal(t, "notes.txt", notes.Filename)
+	assert.Equal(t, "text/plain", notes.ContentType)
+	assert.Equal(t, int64(100), notes.Size)
+
+	// Test: Reading one byte at a time
+	f, err = ParseMultipart(iotest.OneByteReader(strings.NewReader(body)), boundary, DefaultLimits)
+	require.NoError(t, err)
+	assert.Equal(t, "Holiday\r\nphotos", f.Get("title"))
+	photo, _ = f.File("photo")
+	assert.Equal(t, "\xff\xd8 not really a jpeg \r\n--xYz", readAll(t, photo))
+
+	// Test: Large files spill to disk and are removed
+	limits := Limits{MaxParts: 10, MaxFieldSize: 64, MaxMemory: 50}
+	f, err = ParseMultipart(strings.NewReader(body), boundary, limits)
+	require.NoError(t, err)
+	notes, _ = f.File("notes")
+	require.NotEmpty(t, notes.tmpPath)
+	assert.Equal(t, strings.Repeat("n", 100), readAll(t, notes))
+	photo, _ = f.File("photo")
+	assert.Empty(t, photo.tmpPath)
+	require.NoError(t, f.RemoveAll())
+	_, err = os.Stat(notes.tmpPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Too many parts
+	_, err = ParseMultipart(strings.NewReader(body), boundary, Limits{MaxParts: 2, MaxFieldSize: 64, MaxMemory: 1024})
+	require.ErrorIs(t, err, ErrTooManyParts)
+
+	// Test: Field too large
+	_, err = ParseMultipart(strings.NewReader(body), boundary, Limits{MaxParts: 10, MaxFieldSize: 4, MaxMemory: 1024})
+	require.ErrorIs(t, err, ErrFieldTooLarge)
+
+	// Test: Fields over the memory limit
+	_, err = ParseMultipart(strings.NewReader(body), boundary, Limits{MaxParts: 10, MaxFieldSize: 64, MaxMemory: 4})
+	require.ErrorIs(t, err, ErrFormTooLarge)
+
+	// Test: Empty form
+	f, err = ParseMultipart(strings.NewReader("--"+boundary+"--"), boundary, DefaultLimits)
+	require.NoError(t, err)
+	assert.Empty(t, f.Values)
+
+	// Test: Truncated body
+	_, err = ParseMultipart(strings.NewReader(body[:len(body)/2]), boundary, DefaultLimits)
+	require.ErrorIs(t, err, ErrMalformed)
+
+	// Test: No boundary in body
+	_, err = ParseMultipart(strings.NewReader("just some text"), boundary, DefaultLimits)
+	require.ErrorIs(t, err, ErrMalformed)
+
+	// Test: Boundary too long
+	_, err = NewMultipartReader(strings.NewReader(body), strings.Repeat("b", 71))
+	require.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestMultipartReader(t *testing.T) {
+	body := multipartBody(
+		"Content-Disposition: form-data; name=\"a\"\r\nX-Extra: yes\r\n\r\nfirst",
+		"Content-Disposition: form-data; name=\"b\"\r\n\r\nsecond",
+	)
+	mr, err := NewMultipartReader(strings.NewReader(body), boundary)
+	require.NoError(t, err)
+
+	// Test: Part headers
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "a", part.FormName)
+	extra, _ := part.Headers.Get("X-Extra")
+	assert.Equal(t, "yes", extra)
+
+	// Test: Unread parts are skipped
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "b", part.FormName)
+	b, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(b))
+
+	// Test: End of body
+	_, err = mr.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+	_, err = mr.NextPart()
+	require.ErrorIs(t, err, io.EOF)
+}
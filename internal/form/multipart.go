@@ -0,0 +1,211 @@
+package form
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+// maxPartHeaderBytes bounds the headers of a single part
+const maxPartHeaderBytes = 8 << 10
+
+// MultipartReader streams the parts of a multipart body (RFC 2046 section
+// 5.1, RFC 7578). Each part must be read, or skipped by calling NextPart,
+// before moving on.
+type MultipartReader struct {
+	r *bufio.Reader
+	// dashBoundary opens the first part, nlDashBoundary ends every part
+	dashBoundary   []byte
+	nlDashBoundary []byte
+	started        bool
+	done           bool
+	current        *Part
+}
+
+func NewMultipartReader(r io.Reader, boundary string) (*MultipartReader, error) {
+	// RFC 2046 limits boundaries to 70 characters
+	if len(boundary) == 0 || len(boundary) > 70 {
+		return nil, fmt.Errorf("%w: boundary must be 1 to 70 characters", ErrMalformed)
+	}
+	return &MultipartReader{
+		r:              bufio.NewReaderSize(r, 4096),
+		dashBoundary:   []byte("--" + boundary),
+		nlDashBoundary: []byte("\r\n--" + boundary),
+	}, nil
+}
+
+// Part is one part of a multipart body. Reading it returns the part's
+// content up to the next boundary.
+type Part struct {
+	Headers *headers.Headers
+	// FormName and FileName come from the Content-Disposition header
+	FormName    string
+	FileName    string
+	ContentType string
+
+	isFile bool
+	mr     *MultipartReader
+	eof    bool
+}
+
+// NextPart skips whatever is left of the current part and returns the next
+// one, or io.EOF after the closing boundary
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+	}
+
+	if !mr.started {
+		mr.started = true
+		last, err := mr.skipPreamble()
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			mr.done = true
+			return nil, io.EOF
+		}
+	} else {
+		// The previous part consumed the delimiter, so what's left on the
+		// line says whether this was the last one
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(line, []byte("--")) {
+			mr.done = true
+			return nil, io.EOF
+		}
+		if len(bytes.TrimRight(line, " \t\r\n")) != 0 {
+			return nil, fmt.Errorf("%w: unexpected data after boundary", ErrMalformed)
+		}
+	}
+
+	part := &Part{mr: mr, ContentType: "text/plain"}
+	h, err := mr.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+	part.Headers = h
+	if contentType, ok := h.Get("Content-Type"); ok {
+		part.ContentType = contentType
+	}
+	if disposition, ok := h.Get("Content-Disposition"); ok {
+		kind, params, err := headers.ParseMediaType(disposition)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		if kind == "form-data" {
+			part.FormName = params["name"]
+			filename, isFile := params["filename"]
+			part.FileName = baseName(filename)
+			part.isFile = isFile
+		}
+	}
+	mr.current = part
+	return part, nil
+}
+
+// skipPreamble reads up to and including the first boundary line, reporting
+// whether it was the closing boundary of an empty body
+func (mr *MultipartReader) skipPreamble() (bool, error) {
+	for {
+		line, err := mr.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		if bytes.Equal(trimmed, mr.dashBoundary) && err == nil {
+			return false, nil
+		}
+		if rest, ok := bytes.CutPrefix(trimmed, mr.dashBoundary); ok && bytes.Equal(rest, []byte("--")) {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: no boundary found", ErrMalformed)
+		}
+	}
+}
+
+func (mr *MultipartReader) readLine() ([]byte, error) {
+	line, err := mr.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: line too long", ErrMalformed)
+	}
+	if errors.Is(err, io.EOF) {
+		// The closing boundary doesn't need a line break after it
+		if bytes.HasPrefix(line, []byte("--")) {
+			return line, nil
+		}
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, io.ErrUnexpectedEOF)
+	}
+	return line, err
+}
+
+func (mr *MultipartReader) readPartHeaders() (*headers.Headers, error) {
+	h := headers.NewHeaders()
+	total := 0
+	for {
+		line, err := mr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		total += len(line)
+		if total > maxPartHeaderBytes {
+			return nil, fmt.Errorf("%w: part headers are over %d bytes", ErrMalformed, maxPartHeaderBytes)
+		}
+		if !bytes.HasSuffix(line, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: part header line doesn't end in CRLF", ErrMalformed)
+		}
+		if len(line) == 2 {
+			return h, nil
+		}
+		if _, _, err := h.Parse(line); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+	}
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	mr := p.mr
+	delim := len(mr.nlDashBoundary)
+	// Make sure there's enough buffered to spot a delimiter at the start,
+	// then look at everything that has arrived
+	if _, err := mr.r.Peek(delim); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("%w: %w", ErrMalformed, io.ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	buffered, _ := mr.r.Peek(mr.r.Buffered())
+
+	idx := bytes.Index(buffered, mr.nlDashBoundary)
+	if idx == 0 {
+		mr.r.Discard(delim)
+		p.eof = true
+		return 0, io.EOF
+	}
+	safe := idx
+	if idx == -1 {
+		// Hold back enough that a delimiter split across reads is still seen
+		safe = len(buffered) - delim + 1
+	}
+	n := copy(b, buffered[:safe])
+	mr.r.Discard(n)
+	return n, nil
+}
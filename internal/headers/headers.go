@@ -2,26 +2,191 @@ package headers
 
 import (
 	"bytes"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
 
-type Headers map[string]string
+// Field is a single field line as it was received or added
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers holds fields in the order they were added. Names are matched
+// case-insensitively and written in canonical form, while repeated fields
+// are kept as separate lines. Like a nil map, a nil *Headers can be read
+// from but not added to.
+type Headers struct {
+	fields []Field
+}
+
+// TimeFormat is the IMF-fixdate format used for dates in HTTP headers
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
 
-func NewHeaders() Headers {
-	return Headers{}
+// ErrWhitespaceBeforeColon is returned for field lines like "Host : x",
+// which RFC 9112 section 5.1 requires rejecting since proxies disagree on
+// what the field name is
+var ErrWhitespaceBeforeColon = errors.New("whitespace between field name and colon")
+
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	keyLower := strings.ToLower(key)
-	val, ok := h[keyLower]
-	return val, ok
+// Get returns the value of the named field. Repeated fields are combined
+// into one list (RFC 9110 section 5.3), joined with "; " for Cookie and
+// ", " for everything else.
+func (h *Headers) Get(name string) (string, bool) {
+	values := h.Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	sep := ", "
+	// Cookie pairs are separated by semicolons rather than commas
+	if strings.EqualFold(name, "cookie") {
+		sep = "; "
+	}
+	return strings.Join(values, sep), true
+}
+
+// Values returns the value of each line of the named field, in order
+func (h *Headers) Values(name string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+func (h *Headers) Has(name string) bool {
+	if h == nil {
+		return false
+	}
+	return slices.ContainsFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, name)
+	})
+}
+
+// Add appends a field line, keeping any existing lines with the same name
+func (h *Headers) Add(name, value string) {
+	h.fields = append(h.fields, Field{Name: name, Value: value})
+}
+
+// Set replaces any lines of the named field with a single line, which takes
+// the place of the first one
+func (h *Headers) Set(name, value string) {
+	i := slices.IndexFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, name)
+	})
+	if i == -1 {
+		h.Add(name, value)
+		return
+	}
+	h.fields[i] = Field{Name: name, Value: value}
+	rest := deleteNamed(h.fields[i+1:], name)
+	h.fields = h.fields[:i+1+len(rest)]
+}
+
+// Del removes every line of the named field
+func (h *Headers) Del(name string) {
+	h.fields = deleteNamed(h.fields, name)
+}
+
+// Len is the number of field lines
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// All iterates over the field lines in order, with canonical names
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(CanonicalName(f.Name), f.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Fields returns the field lines in order with their names as they were
+// received, for proxies that need to forward them untouched
+func (h *Headers) Fields() []Field {
+	if h == nil {
+		return nil
+	}
+	return slices.Clone(h.fields)
+}
+
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return NewHeaders()
+	}
+	return &Headers{fields: slices.Clone(h.fields)}
+}
+
+// GobEncode lets headers be stored, such as by the cache's disk tier
+func (h *Headers) GobEncode() ([]byte, error) {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(h.fields)
+	return buf.Bytes(), err
+}
+
+func (h *Headers) GobDecode(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&h.fields)
+}
+
+func deleteNamed(fields []Field, name string) []Field {
+	return slices.DeleteFunc(fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, name)
+	})
+}
+
+// canonicalExceptions are names whose usual spelling isn't simply each word
+// capitalised
+var canonicalExceptions = map[string]string{
+	"etag":             "ETag",
+	"te":               "TE",
+	"www-authenticate": "WWW-Authenticate",
+	"content-md5":      "Content-MD5",
+	"dnt":              "DNT",
+}
+
+// CanonicalName capitalises the first letter of each hyphen separated word
+// of a field name and lowercases the rest, so "content-TYPE" becomes
+// "Content-Type"
+func CanonicalName(name string) string {
+	lower := strings.ToLower(name)
+	if canonical, ok := canonicalExceptions[lower]; ok {
+		return canonical
+	}
+	b := []byte(lower)
+	upper := true
+	for i, c := range b {
+		if upper && c >= 'a' && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+		upper = c == '-'
+	}
+	return string(b)
 }
 
 // Parse will parse a byte array header and
 // return the number of bytes read and a done bool
-func (h Headers) Parse(data []byte) (int, bool, error) {
+func (h *Headers) Parse(data []byte) (int, bool, error) {
 	idx := bytes.Index(data, []byte("\r\n"))
 	if idx == -1 {
 		return 0, false, nil
@@ -34,25 +199,19 @@ func (h Headers) Parse(data []byte) (int, bool, error) {
 	if hdrSplitIdx == -1 || hdrSplitIdx == 0 {
 		return 0, false, fmt.Errorf("no valid \":\" found in header line: %v", headerString)
 	}
-	if string(headerString[hdrSplitIdx-1]) == " " {
-		return 0, false, fmt.Errorf("can't have any whitespace before \":\" value")
+	if c := headerString[hdrSplitIdx-1]; c == ' ' || c == '\t' {
+		return 0, false, fmt.Errorf("%w: %q", ErrWhitespaceBeforeColon, headerString)
 	}
-	key := headerString[:hdrSplitIdx]
-	val := headerString[hdrSplitIdx+1:]
-	trimmedLowerKey := strings.ToLower(strings.TrimSpace(key))
-	trimmedVal := strings.TrimSpace(val)
+	key := strings.TrimSpace(headerString[:hdrSplitIdx])
+	val := strings.TrimSpace(headerString[hdrSplitIdx+1:])
 
-	if err := checkForValidKey(trimmedLowerKey); err != nil {
-		return 0, false, fmt.Errorf("couldn't parse header: %w", err)
+	if !ValidName(key) {
+		return 0, false, fmt.Errorf("couldn't parse header: %w: %q", ErrInvalidFieldName, key)
 	}
-
-	v, exists := h[trimmedLowerKey]
-	if exists {
-		v := fmt.Sprintf("%s, %s", v, trimmedVal)
-		h[trimmedLowerKey] = v
-	} else {
-		h[trimmedLowerKey] = trimmedVal
+	if !ValidValue(val) {
+		return 0, false, fmt.Errorf("couldn't parse header: %w for %s", ErrInvalidFieldValue, key)
 	}
+	h.Add(key, val)
 
 	// Check whether we have a carrage return right after previous one
 	nextRtn := bytes.Index(data[idx+2:], []byte("\r\n"))
@@ -62,18 +221,44 @@ func (h Headers) Parse(data []byte) (int, bool, error) {
 	return idx + 2, false, nil
 }
 
-var allowedChars = []string{"!", "#", "$", "%", "&", "'", "*", "+", "-", ".", "^", "_", "`", "|", "~"}
-
-func checkForValidKey(key string) error {
-	if len(key) == 1 {
-		return fmt.Errorf("key is too short")
-	}
-	for _, char := range key {
-		if !(char >= 'A' && char <= 'z') &&
-			!(char >= '0' && char <= '9') &&
-			!slices.Contains(allowedChars, string(char)) {
-			return fmt.Errorf("key %q has incorrect character: %s", key, string(char))
+// ParseMediaType splits a Content-Type style value into its lowercased type
+// and parameters, e.g. `multipart/form-data; boundary="abc"`. Parameter names
+// are lowercased and quoted values are unescaped.
+func ParseMediaType(value string) (string, map[string]string, error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	params := map[string]string{}
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		if rest == "" {
+			return mediaType, params, nil
+		}
+		key, val, found := strings.Cut(rest, "=")
+		if !found {
+			return mediaType, params, fmt.Errorf("media type parameter %q has no value", key)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			return mediaType, params, fmt.Errorf("media type parameter has no name")
+		}
+		val = strings.TrimLeft(val, " \t")
+		if !strings.HasPrefix(val, `"`) {
+			val, rest, _ = strings.Cut(val, ";")
+			params[key] = strings.TrimSpace(val)
+			continue
+		}
+		b := strings.Builder{}
+		i := 1
+		for ; i < len(val) && val[i] != '"'; i++ {
+			if val[i] == '\\' && i+1 < len(val) {
+				i++
+			}
+			b.WriteByte(val[i])
+		}
+		if i >= len(val) {
+			return mediaType, params, fmt.Errorf("unterminated quoted string in media type parameter %q", key)
 		}
+		params[key] = b.String()
+		rest = val[i+1:]
 	}
-	return nil
 }
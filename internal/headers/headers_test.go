@@ -14,7 +14,8 @@ func TestParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	host, _ := headers.Get("host")
+	assert.Equal(t, "localhost:42069", host)
 	assert.Equal(t, 23, n)
 	assert.True(t, done)
 
@@ -26,6 +27,12 @@ func TestParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Tab before the colon
+	headers = NewHeaders()
+	data = []byte("Host\t: localhost:42069\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrWhitespaceBeforeColon)
+
 	// Test: Valid spacing header
 	headers = NewHeaders()
 	data = []byte("       Host: localhost:42069       \r\n\r\n")
@@ -49,7 +56,8 @@ func TestParse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 23, n)
 	assert.True(t, done)
-	assert.Equal(t, headers["host"], "localhost:42069")
+	host, _ = headers.Get("host")
+	assert.Equal(t, "localhost:42069", host)
 
 	// Test: Invalid header chars
 	headers = NewHeaders()
@@ -59,15 +67,41 @@ func TestParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Brackets aren't token characters
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X[1]: a\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldName)
+
+	// Test: Single character names are tokens too
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X: a\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Control characters in values are rejected
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Name: a\x00b\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+	_, _, err = headers.Parse([]byte("X-Name: a\x7fb\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+
+	// Test: obs-text in values is kept as it is
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Name: caf\xe9\r\n\r\n"))
+	require.NoError(t, err)
+	name, _ := headers.Get("x-name")
+	assert.Equal(t, "caf\xe9", name)
+
 	// Test: Multiple headers with same key
 	headers = NewHeaders()
-	headers["name"] = "Mike"
+	headers.Add("name", "Mike")
 	data = []byte("name: Barry\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	assert.Equal(t, 13, n)
 	assert.True(t, done)
-	assert.Equal(t, "Mike, Barry", headers["name"])
+	name, _ = headers.Get("name")
+	assert.Equal(t, "Mike, Barry", name)
+	assert.Equal(t, []string{"Mike", "Barry"}, headers.Values("Name"))
 
 	// Test: Headers including user-agent
 	headers = NewHeaders()
@@ -76,7 +110,8 @@ func TestParse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 25, n)
 	assert.True(t, done)
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	userAgent, _ := headers.Get("user-agent")
+	assert.Equal(t, "curl/7.81.0", userAgent)
 
 	// Test: Headers including accept headers
 	headers = NewHeaders()
@@ -85,5 +120,110 @@ func TestParse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 14, n)
 	assert.True(t, done)
-	assert.Equal(t, "/*/*", headers["accept"])
+	accept, _ := headers.Get("accept")
+	assert.Equal(t, "/*/*", accept)
+}
+
+func TestParseMediaType(t *testing.T) {
+	// Test: No parameters
+	mediaType, params, err := ParseMediaType("Text/HTML")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", mediaType)
+	assert.Empty(t, params)
+
+	// Test: Plain and quoted parameters
+	mediaType, params, err = ParseMediaType(`multipart/form-data; Boundary="a \"b\"; c"; charset=utf-8`)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	assert.Equal(t, `a "b"; c`, params["boundary"])
+	assert.Equal(t, "utf-8", params["charset"])
+
+	// Test: Unterminated quote
+	_, _, err = ParseMediaType(`text/plain; charset="utf-8`)
+	require.Error(t, err)
+
+	// Test: Parameter without a value
+	_, _, err = ParseMediaType("text/plain; charset")
+	require.Error(t, err)
+}
+
+func TestHeaders(t *testing.T) {
+	// Test: Repeated fields stay separate lines in insertion order
+	h := NewHeaders()
+	h.Add("content-type", "text/html")
+	h.Add("Set-Cookie", "a=1")
+	h.Add("set-cookie", "b=2")
+	h.Add("X-Request-Id", "42")
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("SET-COOKIE"))
+	assert.Equal(t, 4, h.Len())
+	var names []string
+	for name := range h.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Content-Type", "Set-Cookie", "Set-Cookie", "X-Request-Id"}, names)
+
+	// Test: Set replaces every line in place of the first
+	h.Set("Set-Cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, h.Values("set-cookie"))
+	assert.Equal(t, []Field{
+		{Name: "content-type", Value: "text/html"},
+		{Name: "Set-Cookie", Value: "c=3"},
+		{Name: "X-Request-Id", Value: "42"},
+	}, h.Fields())
+
+	// Test: Del removes the field and lookups report it missing
+	h.Del("x-request-id")
+	_, ok := h.Get("X-Request-Id")
+	assert.False(t, ok)
+	assert.False(t, h.Has("x-request-id"))
+
+	// Test: Cookie lines are joined with semicolons
+	h = NewHeaders()
+	h.Add("Cookie", "a=1")
+	h.Add("Cookie", "b=2")
+	cookie, _ := h.Get("cookie")
+	assert.Equal(t, "a=1; b=2", cookie)
+
+	// Test: Clones don't share fields
+	clone := h.Clone()
+	clone.Set("Cookie", "c=3")
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("Cookie"))
+
+	// Test: Nil headers can be read
+	var nilHeaders *Headers
+	_, ok = nilHeaders.Get("Host")
+	assert.False(t, ok)
+	assert.Equal(t, 0, nilHeaders.Len())
+
+	// Test: Gob round trip keeps order and raw names
+	data, err := h.GobEncode()
+	require.NoError(t, err)
+	decoded := NewHeaders()
+	require.NoError(t, decoded.GobDecode(data))
+	assert.Equal(t, h.Fields(), decoded.Fields())
+}
+
+func TestCanonicalName(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalName("content-TYPE"))
+	assert.Equal(t, "X-Forwarded-For", CanonicalName("x-forwarded-for"))
+	assert.Equal(t, "ETag", CanonicalName("etag"))
+	assert.Equal(t, "WWW-Authenticate", CanonicalName("www-authenticate"))
+}
+
+func TestValidate(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Location", "/next")
+	require.NoError(t, h.Validate())
+
+	// Test: CRLF in a value could inject another field
+	h.Set("Location", "/next\r\nSet-Cookie: admin=1")
+	require.ErrorIs(t, h.Validate(), ErrInvalidFieldValue)
+
+	// Test: Names must be tokens
+	h = NewHeaders()
+	h.Set("Bad Name", "x")
+	require.ErrorIs(t, h.Validate(), ErrInvalidFieldName)
+	h = NewHeaders()
+	h.Set("", "x")
+	require.ErrorIs(t, h.Validate(), ErrInvalidFieldName)
 }
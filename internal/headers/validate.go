@@ -0,0 +1,85 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidFieldName  = errors.New("invalid field name")
+	ErrInvalidFieldValue = errors.New("invalid field value")
+)
+
+// tchar marks the characters allowed in a token (RFC 9110 section 5.6.2):
+// letters, digits and "!#$%&'*+-.^_`|~"
+var tchar = func() [256]bool {
+	var t [256]bool
+	for c := '0'; c <= '9'; c++ {
+		t[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		t[c] = true
+		t[c-'a'+'A'] = true
+	}
+	for _, c := range "!#$%&'*+-.^_`|~" {
+		t[c] = true
+	}
+	return t
+}()
+
+// fieldChar marks the bytes allowed in a field value (RFC 9110 section 5.5):
+// visible characters, space, tab and obs-text, which is passed through as
+// opaque bytes. This leaves out NUL, CR, LF and the other controls.
+var fieldChar = func() [256]bool {
+	var t [256]bool
+	t[' '] = true
+	t['\t'] = true
+	for c := 0x21; c <= 0x7e; c++ {
+		t[c] = true
+	}
+	for c := 0x80; c <= 0xff; c++ {
+		t[c] = true
+	}
+	return t
+}()
+
+// ValidName reports whether name is a token, as field names must be
+func ValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !tchar[name[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidValue reports whether value can be sent as a field value. Anything
+// holding a CR or LF is refused, since it could end the field line early and
+// start another.
+func ValidValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if !fieldChar[value[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks every field line can be written as it is
+func (h *Headers) Validate() error {
+	if h == nil {
+		return nil
+	}
+	for _, f := range h.fields {
+		if !ValidName(f.Name) {
+			return fmt.Errorf("%w: %q", ErrInvalidFieldName, f.Name)
+		}
+		if !ValidValue(f.Value) {
+			return fmt.Errorf("%w for %s: %q", ErrInvalidFieldValue, f.Name, f.Value)
+		}
+	}
+	return nil
+}
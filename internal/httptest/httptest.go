@@ -0,0 +1,42 @@
+// Package httptest helps test handlers and the server without opening ports
+package httptest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/server"
+)
+
+// NewRequest parses a raw request, panicking if it is malformed. Bare LFs in
+// the request line and headers are turned into CRLFs so requests can be
+// written as plain multi-line strings, while the body is left as it is.
+func NewRequest(raw string) *request.Request {
+	head, body, found := strings.Cut(raw, "\n\n")
+	if !found {
+		head, body, _ = strings.Cut(raw, "\r\n\r\n")
+	}
+	head = strings.TrimRight(head, "\r\n")
+	head = strings.ReplaceAll(strings.ReplaceAll(head, "\r\n", "\n"), "\n", "\r\n")
+	req, err := request.RequestFromReader(strings.NewReader(head + "\r\n\r\n" + body))
+	if err != nil {
+		panic(fmt.Sprintf("httptest: invalid request: %v", err))
+	}
+	return req
+}
+
+// RoundTrip serves raw with s over an in-memory connection and returns
+// everything written back before the connection was closed
+func RoundTrip(s *server.Server, raw string) (string, error) {
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(conn)
+	go func() {
+		client.Write([]byte(raw))
+	}()
+	resp, err := io.ReadAll(client)
+	return string(resp), err
+}
@@ -0,0 +1,118 @@
+package httptest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/2bitburrito/http-implementation/internal/cookie"
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/2bitburrito/http-implementation/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRequest(t *testing.T) {
+	// Test: Plain line endings are accepted
+	req := NewRequest("POST /submit?x=1 HTTP/1.1\nHost: localhost\nContent-Length: 5\n\nhello")
+	assert.Equal(t, "POST", req.RequestLine.Method)
+	assert.Equal(t, "/submit", req.Target.Path)
+	host, _ := req.Headers.Get("Host")
+	assert.Equal(t, "localhost", host)
+	body := make([]byte, 5)
+	_, err := req.Body.Read(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Malformed requests panic
+	assert.Panics(t, func() { NewRequest("nonsense") })
+}
+
+func TestRecorder(t *testing.T) {
+	handler := func(w response.Writer, req *request.Request) {
+		w.SetCookie(&cookie.Cookie{Name: "a", Value: "1"})
+		w.WriteStatusLine(response.Accepted)
+		h := headers.NewHeaders()
+		h.Set("X-Second", "2")
+		h.Set("X-First", "1")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("part one, "))
+		w.WriteChunkedBody([]byte("part two"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc")
+		w.(response.TrailerWriter).WriteTrailers(trailers)
+	}
+
+	// Test: Status, ordered headers, body and trailers are captured
+	rec := NewRecorder()
+	handler(rec, NewRequest("GET / HTTP/1.1\nHost: localhost\n"))
+	assert.Equal(t, response.Accepted, rec.Code)
+	assert.Equal(t, "Accepted", rec.Reason)
+	var names []string
+	for name := range rec.Headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"X-Second", "X-First", "Set-Cookie"}, names)
+	assert.Equal(t, "part one, part two", rec.Body.String())
+	checksum, _ := rec.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: A handler that only writes a body gets a 200
+	rec = NewRecorder()
+	rec.WriteBody([]byte("hi"))
+	assert.Equal(t, response.OK, rec.Code)
+	assert.Equal(t, "hi", rec.Body.String())
+
+	// Test: Write order is enforced
+	require.ErrorIs(t, rec.WriteStatusLine(response.NotFound), response.ErrWriteOrder)
+
+	// Test: Interim responses are followed by the final one
+	rec = NewRecorder()
+	hints := headers.NewHeaders()
+	hints.Set("Link", "</style.css>; rel=preload")
+	require.NoError(t, rec.WriteStatusLine(response.EarlyHints))
+	require.NoError(t, rec.WriteHeaders(hints))
+	require.NoError(t, rec.WriteStatusLine(response.OK))
+	require.NoError(t, rec.WriteHeaders(headers.NewHeaders()))
+	require.Len(t, rec.Interim, 1)
+	assert.Equal(t, response.EarlyHints, rec.Interim[0].Code)
+	assert.True(t, rec.Interim[0].Headers.Has("Link"))
+	assert.Equal(t, response.OK, rec.Code)
+	assert.False(t, rec.Headers.Has("Link"))
+
+	// Test: Framing fields are dropped where there is no body
+	rec = NewRecorder()
+	h := headers.NewHeaders()
+	h.Set("Content-Length", "5")
+	h.Set("Transfer-Encoding", "chunked")
+	rec.WriteStatusLine(response.NoContent)
+	require.NoError(t, rec.WriteHeaders(h))
+	assert.False(t, rec.Headers.Has("Content-Length"))
+	assert.False(t, rec.Headers.Has("Transfer-Encoding"))
+	rec = NewRecorder()
+	h = headers.NewHeaders()
+	h.Set("Content-Length", "5")
+	rec.WriteStatusLine(response.NotModified)
+	require.NoError(t, rec.WriteHeaders(h))
+	assert.True(t, rec.Headers.Has("Content-Length"))
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := &server.Server{Handler: func(w response.Writer, req *request.Request) {
+		w.WriteBody([]byte("hello " + req.Target.Path))
+	}}
+
+	// Test: The full server runs without a listener
+	resp, err := RoundTrip(s, "GET /pipe HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "Content-Length: 11\r\n")
+	assert.Contains(t, resp, "\r\n\r\nhello /pipe")
+
+	// Test: Malformed requests get the server's error response
+	resp, err = RoundTrip(s, "GET /pipe HTTP/1.1\nHost: localhost\n\n")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+}
@@ -0,0 +1,193 @@
+package httptest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/2bitburrito/http-implementation/internal/cookie"
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/response"
+)
+
+// ResponseRecorder is a response.Writer that keeps what a handler writes so
+// tests can look at it. Body filters are run as they would be by the server,
+// so Body holds what they wrote, but no chunk framing is added by the
+// recorder itself. Write order and the framing fields of responses without
+// a body are handled as the server's writer handles them.
+type ResponseRecorder struct {
+	Code   response.StatusCode
+	Reason string
+	// Headers are the fields in the order they would have been written,
+	// including any Set-Cookie lines
+	Headers *headers.Headers
+	// Interim holds the 1xx responses sent ahead of the final one
+	Interim  []InterimResponse
+	Body     *bytes.Buffer
+	Trailers *headers.Headers
+	// Flushed is set once the handler has called Flush
+	Flushed bool
+
+	wroteStatus  bool
+	wroteHeaders bool
+	finished     bool
+	filters      []response.BodyFilter
+	body         io.WriteCloser
+	cookies      []string
+}
+
+// InterimResponse is a 1xx response other than 101, which is followed by
+// another status line
+type InterimResponse struct {
+	Code    response.StatusCode
+	Reason  string
+	Headers *headers.Headers
+}
+
+func NewRecorder() *ResponseRecorder {
+	return &ResponseRecorder{
+		Code:    response.OK,
+		Reason:  response.StatusText(response.OK),
+		Headers: headers.NewHeaders(),
+		Body:    &bytes.Buffer{},
+	}
+}
+
+func (r *ResponseRecorder) WriteStatusLine(statusCode response.StatusCode) error {
+	return r.WriteStatusLineReason(statusCode, response.StatusText(statusCode))
+}
+
+func (r *ResponseRecorder) WriteStatusLineReason(statusCode response.StatusCode, reason string) error {
+	if r.wroteStatus {
+		return fmt.Errorf("%w: status line already written", response.ErrWriteOrder)
+	}
+	r.Code = statusCode
+	r.Reason = reason
+	r.wroteStatus = true
+	return nil
+}
+
+func (r *ResponseRecorder) WriteHeaders(h *headers.Headers) error {
+	if !r.wroteStatus {
+		r.WriteStatusLine(response.OK)
+	}
+	if r.wroteHeaders {
+		return fmt.Errorf("%w: headers already written", response.ErrWriteOrder)
+	}
+	interim := r.Code >= 100 && r.Code < 200 && r.Code != response.SwitchingProtocols
+	if !interim {
+		var dst io.Writer = r.Body
+		for _, f := range r.filters {
+			if wc := f(r.Code, h, dst); wc != nil {
+				r.body = wc
+				dst = wc
+			}
+		}
+	}
+	if !response.BodyAllowed(r.Code) {
+		r.body = nil
+		h.Del("Transfer-Encoding")
+		if r.Code != response.NotModified {
+			h.Del("Content-Length")
+		}
+	}
+	if err := h.Validate(); err != nil {
+		return fmt.Errorf("couldn't write headers: %w", err)
+	}
+	// Another status line follows an interim response
+	if interim {
+		r.Interim = append(r.Interim, InterimResponse{Code: r.Code, Reason: r.Reason, Headers: h.Clone()})
+		r.wroteStatus = false
+		return nil
+	}
+	r.Headers = h.Clone()
+	for _, c := range r.cookies {
+		r.Headers.Add("Set-Cookie", c)
+	}
+	r.wroteHeaders = true
+	// Nothing follows a 101 but the new protocol
+	if r.Code == response.SwitchingProtocols {
+		r.finished = true
+	}
+	return nil
+}
+
+func (r *ResponseRecorder) WriteBody(p []byte) (int, error) {
+	if !r.wroteHeaders {
+		if err := r.WriteHeaders(headers.NewHeaders()); err != nil {
+			return 0, err
+		}
+	}
+	if r.finished {
+		return 0, fmt.Errorf("%w: body already finished", response.ErrWriteOrder)
+	}
+	if len(p) > 0 && !response.BodyAllowed(r.Code) {
+		return 0, fmt.Errorf("%w: %d", response.ErrBodyNotAllowed, r.Code)
+	}
+	if r.body != nil {
+		return r.body.Write(p)
+	}
+	return r.Body.Write(p)
+}
+
+func (r *ResponseRecorder) WriteChunkedBody(p []byte) (int, error) {
+	return r.WriteBody(p)
+}
+
+func (r *ResponseRecorder) WriteChunkedBodyDone() (int, error) {
+	if _, err := r.WriteBody(nil); err != nil {
+		return 0, err
+	}
+	r.finished = true
+	if r.body != nil {
+		return 0, r.body.Close()
+	}
+	return 0, nil
+}
+
+func (r *ResponseRecorder) WriteTrailers(h *headers.Headers) error {
+	if !r.finished {
+		return fmt.Errorf("%w: trailers must follow the last chunk", response.ErrWriteOrder)
+	}
+	if err := h.Validate(); err != nil {
+		return fmt.Errorf("couldn't write trailers: %w", err)
+	}
+	r.Trailers = h.Clone()
+	return nil
+}
+
+func (r *ResponseRecorder) SetCookie(c *cookie.Cookie) error {
+	if r.wroteHeaders {
+		return fmt.Errorf("%w: cookie set after the headers", response.ErrWriteOrder)
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	r.cookies = append(r.cookies, c.String())
+	return nil
+}
+
+func (r *ResponseRecorder) AddBodyFilter(f response.BodyFilter) {
+	r.filters = append(r.filters, f)
+}
+
+func (r *ResponseRecorder) Flush() error {
+	r.Flushed = true
+	return nil
+}
+
+// Close finishes a body written through a filter, as the server does once
+// the handler returns
+func (r *ResponseRecorder) Close() error {
+	if r.body == nil || r.finished {
+		return nil
+	}
+	r.finished = true
+	return r.body.Close()
+}
+
+var _ interface {
+	response.Writer
+	response.Flusher
+	response.TrailerWriter
+} = (*ResponseRecorder)(nil)
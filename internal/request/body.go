@@ -0,0 +1,109 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxDrainSize is how much of an unread body Close will read and discard so
+// the connection can be reused. Anything larger means the connection must
+// be closed instead.
+var MaxDrainSize int64 = 256 << 10
+
+var (
+	ErrBodyTruncated  = errors.New("request body is shorter than content-length")
+	ErrBodyNotDrained = errors.New("request body too large to drain")
+	ErrBodyClosed     = errors.New("read on closed request body")
+)
+
+// NoBody is the Body of requests without one
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body reads exactly Content-Length bytes from the connection as the
+// handler asks for them
+type body struct {
+	r         io.Reader
+	remaining int64
+	closed    bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && b.remaining > 0 {
+		return n, fmt.Errorf("%w: %d bytes missing", ErrBodyTruncated, b.remaining)
+	}
+	return n, err
+}
+
+// Close discards whatever the handler didn't read, so that the next request
+// on the connection starts in the right place. It returns ErrBodyNotDrained
+// when that is more than MaxDrainSize.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	if b.remaining > MaxDrainSize {
+		b.closed = true
+		return fmt.Errorf("%w: %d bytes left", ErrBodyNotDrained, b.remaining)
+	}
+	_, err := io.Copy(io.Discard, b)
+	b.closed = true
+	return err
+}
+
+// setupBody points Body at the rest of the message. buffered holds whatever
+// was read from the connection past the end of the headers.
+func (r *Request) setupBody(buffered []byte, conn io.Reader) error {
+	if err := r.checkFraming(); err != nil {
+		return err
+	}
+	if te, ok := r.Headers.Get("Transfer-Encoding"); ok {
+		if !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, te)
+		}
+		r.ContentLength = -1
+		r.Body = newChunkedBody(r, io.MultiReader(bytes.NewReader(buffered), conn))
+		return r.decodeBody()
+	}
+	contentLen, ok := r.Headers.Get("Content-Length")
+	if !ok {
+		r.Body = NoBody
+		return nil
+	}
+	length, err := strconv.ParseInt(contentLen, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidContentLength, contentLen)
+	}
+	r.ContentLength = length
+	if length > r.limits.MaxBodySize {
+		return fmt.Errorf("%w: content-length %d is over the %d byte limit", ErrBodyTooLarge, length, r.limits.MaxBodySize)
+	}
+	if length == 0 {
+		r.Body = NoBody
+		return nil
+	}
+	r.Body = &body{
+		r:         io.MultiReader(bytes.NewReader(buffered), conn),
+		remaining: length,
+	}
+	return r.decodeBody()
+}
@@ -0,0 +1,208 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+var (
+	// MaxChunkLineSize bounds a chunk-size line, including any extensions,
+	// and each trailer field line
+	MaxChunkLineSize = 4096
+	// MaxTrailerSize bounds all of the trailer fields together
+	MaxTrailerSize = 8 << 10
+)
+
+var (
+	ErrUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+	ErrMalformedChunk              = errors.New("malformed chunked body")
+	ErrChunkLineTooLong            = errors.New("chunk line too long")
+)
+
+// chunkedBody decodes the chunked transfer coding (RFC 9112 section 7.1),
+// handing trailer fields on once the last chunk is read
+type chunkedBody struct {
+	r *bufio.Reader
+	// limit bounds the total chunk data, with 0 meaning no limit
+	limit      int64
+	onTrailers func(*headers.Headers)
+	// remaining is what's left of the current chunk's data
+	remaining int64
+	total     int64
+	started   bool
+	done      bool
+	closed    bool
+	err       error
+}
+
+func newChunkedBody(req *Request, r io.Reader) *chunkedBody {
+	return &chunkedBody{
+		r:          bufio.NewReaderSize(r, max(MaxChunkLineSize, 16)),
+		limit:      req.limits.MaxBodySize,
+		onTrailers: func(h *headers.Headers) { req.Trailers = h },
+	}
+}
+
+// NewChunkedReader decodes a chunked body from r, such as one sent in a
+// response. It fails with ErrBodyTooLarge past maxSize bytes of chunk data,
+// unless maxSize is 0. Once the last chunk has been read the trailer fields
+// are passed to trailers, which may be nil.
+//
+// A *bufio.Reader of at least MaxChunkLineSize is read from directly, so
+// nothing past the end of the body is consumed from it.
+func NewChunkedReader(r io.Reader, maxSize int64, trailers func(*headers.Headers)) io.ReadCloser {
+	if trailers == nil {
+		trailers = func(*headers.Headers) {}
+	}
+	return &chunkedBody{
+		r:          bufio.NewReaderSize(r, max(MaxChunkLineSize, 16)),
+		limit:      maxSize,
+		onTrailers: trailers,
+	}
+}
+
+func (c *chunkedBody) Read(p []byte) (int, error) {
+	if c.closed {
+		return 0, ErrBodyClosed
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: %w", ErrMalformedChunk, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk finishes the previous chunk and reads the next chunk-size line,
+// reading the trailer section if it is the last chunk
+func (c *chunkedBody) nextChunk() error {
+	if c.started {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) != 0 {
+			return fmt.Errorf("%w: chunk data is longer than its size", ErrMalformedChunk)
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	// Extensions follow a semicolon and are ignored
+	sizeStr, _, _ := strings.Cut(string(line), ";")
+	sizeStr = strings.TrimRight(sizeStr, " \t")
+	// ParseInt would also accept a sign, so check for hex digits first
+	if !isHexDigits(sizeStr) {
+		return fmt.Errorf("%w: %w %q", ErrMalformedChunk, ErrInvalidChunkSize, sizeStr)
+	}
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %w %q", ErrMalformedChunk, ErrInvalidChunkSize, sizeStr)
+	}
+	if size == 0 {
+		c.done = true
+		return c.readTrailers()
+	}
+	c.total += size
+	if c.limit > 0 && c.total > c.limit {
+		return fmt.Errorf("%w: chunked body exceeds %d bytes", ErrBodyTooLarge, c.limit)
+	}
+	c.remaining = size
+	return nil
+}
+
+func (c *chunkedBody) readTrailers() error {
+	trailers := headers.NewHeaders()
+	total := 0
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			c.onTrailers(trailers)
+			return nil
+		}
+		total += len(line)
+		if total > MaxTrailerSize {
+			return fmt.Errorf("%w: trailers exceed %d bytes", ErrMalformedChunk, MaxTrailerSize)
+		}
+		if _, _, err := trailers.Parse(append(line, '\r', '\n')); err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedChunk, err)
+		}
+	}
+}
+
+// readLine returns the next CRLF terminated line without the CRLF
+func (c *chunkedBody) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrChunkLineTooLong, MaxChunkLineSize)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedChunk, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLineEndings(line); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedChunk, err)
+	}
+	return bytes.Clone(line[:len(line)-2]), nil
+}
+
+// Close discards the rest of the body, up to MaxDrainSize bytes of chunk data
+func (c *chunkedBody) Close() error {
+	if c.closed {
+		return nil
+	}
+	n, err := io.CopyN(io.Discard, c, MaxDrainSize+1)
+	c.closed = true
+	if n > MaxDrainSize {
+		return fmt.Errorf("%w: over %d bytes left", ErrBodyNotDrained, MaxDrainSize)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func isHexDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHex(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
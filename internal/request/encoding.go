@@ -0,0 +1,105 @@
+package request
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrBodyTooLarge        = errors.New("request body too large")
+)
+
+// decodeBody wraps a compressed body in readers that decode it and removes
+// the Content-Encoding header so handlers only ever see the plain body. The
+// decoded length isn't known up front so Content-Length is removed too.
+// Codings are checked here, but nothing is read from the body until the
+// handler reads it.
+func (r *Request) decodeBody() error {
+	contentEncoding, ok := r.Headers.Get("Content-Encoding")
+	if !ok {
+		return nil
+	}
+	codings := strings.Split(contentEncoding, ",")
+	decoded := &decodedBody{raw: r.Body}
+	// Codings are listed in the order they were applied so undo them backwards
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		switch coding {
+		case "identity", "":
+		case "gzip", "x-gzip", "deflate":
+			decoded.codings = append(decoded.codings, coding)
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+		}
+	}
+	limit := r.limits.MaxDecodedBodySize
+	r.Body = &limitedBody{ReadCloser: decoded, left: limit, limit: limit}
+	r.Headers.Del("Content-Encoding")
+	r.Headers.Del("Content-Length")
+	return nil
+}
+
+func decode(coding string, body io.Reader) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	var err error
+	switch coding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(body)
+	case "deflate":
+		reader, err = zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode %s body: %w", coding, err)
+	}
+	return reader, nil
+}
+
+// decodedBody reads through the decoders, closing them and the raw body
+// together. The decoders read a header of their own when they are made, so
+// they are only made on the first Read.
+type decodedBody struct {
+	// codings are in the order they are undone
+	codings  []string
+	r        io.Reader
+	err      error
+	decoders []io.ReadCloser
+	raw      io.ReadCloser
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.err = d.open()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decodedBody) open() error {
+	var reader io.Reader = d.raw
+	for _, coding := range d.codings {
+		decoder, err := decode(coding, reader)
+		if err != nil {
+			return err
+		}
+		d.decoders = append(d.decoders, decoder)
+		reader = decoder
+	}
+	d.r = reader
+	return nil
+}
+
+func (d *decodedBody) Close() error {
+	for _, decoder := range d.decoders {
+		decoder.Close()
+	}
+	return d.raw.Close()
+}
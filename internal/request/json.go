@@ -0,0 +1,59 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+// DefaultMaxJSONSize is the body size limit DecodeJSON uses when given 0
+const DefaultMaxJSONSize = 1 << 20
+
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrInvalidJSON          = errors.New("invalid json body")
+)
+
+// DecodeJSON decodes the body into v. The Content-Type must be
+// application/json or a +json type, fields not in v are rejected and bodies
+// over maxSize bytes fail with ErrBodyTooLarge.
+func (r *Request) DecodeJSON(v any, maxSize int64) error {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxJSONSize
+	}
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, _, err := headers.ParseMediaType(contentType)
+	if err != nil || !isJSON(mediaType) {
+		return fmt.Errorf("%w: expected application/json, got %q", ErrUnsupportedMediaType, contentType)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > maxSize {
+		return fmt.Errorf("%w: body is over the %d byte limit", ErrBodyTooLarge, maxSize)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: body is empty", ErrInvalidJSON)
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+	}
+	// A body is a single value, anything after it is a mistake
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: unexpected data after the json value", ErrInvalidJSON)
+	}
+	return nil
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,92 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Limits bounds how much of a request is read. Fields left at zero use the
+// value from DefaultLimits.
+type Limits struct {
+	// MaxRequestLineSize bounds the request line, not counting its CRLF
+	MaxRequestLineSize int
+	// MaxHeaderBytes bounds the header section, including line endings
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	// MaxBodySize bounds the body as sent, before any content coding is undone
+	MaxBodySize int64
+	// MaxDecodedBodySize bounds how large a compressed body may grow once
+	// decoded, protecting against decompression bombs
+	MaxDecodedBodySize int64
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineSize: 8 << 10,
+	MaxHeaderBytes:     64 << 10,
+	MaxHeaderCount:     100,
+	MaxBodySize:        64 << 20,
+	MaxDecodedBodySize: 10 << 20,
+}
+
+var (
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeadersTooLarge    = errors.New("request header section too large")
+)
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineSize <= 0 {
+		l.MaxRequestLineSize = DefaultLimits.MaxRequestLineSize
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount <= 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodySize <= 0 {
+		l.MaxBodySize = DefaultLimits.MaxBodySize
+	}
+	if l.MaxDecodedBodySize <= 0 {
+		l.MaxDecodedBodySize = DefaultLimits.MaxDecodedBodySize
+	}
+	return l
+}
+
+// LimitBody lowers the body size limit for this request, such as for a
+// route that only takes small bodies. A Content-Length over the limit fails
+// straight away, other bodies fail with ErrBodyTooLarge once they pass it.
+// The limit applies to the body as the handler reads it, so it bounds a
+// decoded body too.
+func (r *Request) LimitBody(n int64) error {
+	if r.ContentLength > n {
+		return fmt.Errorf("%w: content-length %d is over the %d byte limit", ErrBodyTooLarge, r.ContentLength, n)
+	}
+	if r.Body != NoBody {
+		r.Body = &limitedBody{ReadCloser: r.Body, left: n, limit: n}
+	}
+	return nil
+}
+
+// limitedBody fails with ErrBodyTooLarge rather than stopping quietly once
+// more than limit bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	left  int64
+	limit int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, fmt.Errorf("%w: body exceeds %d bytes", ErrBodyTooLarge, l.limit)
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n + int(l.left), fmt.Errorf("%w: body exceeds %d bytes", ErrBodyTooLarge, l.limit)
+	}
+	return n, err
+}
@@ -7,19 +7,32 @@ import (
 	"fmt"
 	"io"
 	"slices"
-	"strconv"
 	"strings"
 
+	"github.com/2bitburrito/http-implementation/internal/cookie"
 	"github.com/2bitburrito/http-implementation/internal/headers"
 )
 
 type Request struct {
-	RequestLine       RequestLine
-	Headers           headers.Headers
-	State             RequestState
-	Body              []byte
-	reportedConentLen int
-	totalBodyParsed   int
+	RequestLine RequestLine
+	Target      Target
+	Headers     *headers.Headers
+	State       RequestState
+	// Body streams the request body from the connection. It is never nil,
+	// and the server closes it once the handler returns.
+	Body io.ReadCloser
+	// ContentLength is the length of the body as sent, 0 if there isn't one
+	// or -1 if it is chunked
+	ContentLength int64
+	// Trailers holds the trailer fields of a chunked body. It is only filled
+	// in once the body has been read to the end.
+	Trailers *headers.Headers
+	// Principal is the authenticated user, set by authentication middleware
+	Principal string
+
+	limits      Limits
+	headerBytes int
+	headerCount int
 }
 
 type RequestState int
@@ -27,7 +40,6 @@ type RequestState int
 const (
 	requestStateInitialised RequestState = iota
 	requestParsingHeaders
-	requestParsingBody
 	requestStateDone
 )
 
@@ -37,15 +49,32 @@ type RequestLine struct {
 	RequestTarget string
 }
 
-var allowedMethods = []string{"GET", "POST", "PUT", "DETELE", "PATCH"}
+var allowedMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS", "CONNECT"}
+
+var (
+	ErrMissingHost   = errors.New("missing host header")
+	ErrDuplicateHost = errors.New("duplicate host header")
+	ErrInvalidHost   = errors.New("invalid host header")
+)
 
+// RequestFromReader reads the request line and headers using DefaultLimits.
+// The body is left on the reader to be streamed through Body.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buffer := make([]byte, 8)
+	return RequestFromReaderLimited(reader, DefaultLimits)
+}
+
+// RequestFromReaderLimited is RequestFromReader with the given limits, which
+// are checked as the request arrives so oversized requests are refused
+// without reading the excess
+func RequestFromReaderLimited(reader io.Reader, limits Limits) (*Request, error) {
+	buffer := make([]byte, 1024)
 	currReadIdx := 0
 
 	req := &Request{
-		State:   requestStateInitialised,
-		Headers: headers.NewHeaders(),
+		State:    requestStateInitialised,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   limits.withDefaults(),
 	}
 
 	for req.State != requestStateDone {
@@ -54,15 +83,11 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			copy(newSlice, buffer)
 			buffer = newSlice
 		}
-		numberBytesRead, err := reader.Read(buffer[currReadIdx:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				req.State = requestStateDone
-				break
-			}
-			return nil, err
-		}
+		numberBytesRead, readErr := reader.Read(buffer[currReadIdx:])
 		currReadIdx += numberBytesRead
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return nil, readErr
+		}
 
 		numberBytesParsed, err := req.parseLoop(buffer[:currReadIdx])
 		if err != nil {
@@ -71,18 +96,55 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 
 		copy(buffer, buffer[numberBytesParsed:])
 		currReadIdx -= numberBytesParsed
+		if errors.Is(readErr, io.EOF) && req.State != requestStateDone {
+			return nil, fmt.Errorf("connection closed before the end of the headers: %w", io.ErrUnexpectedEOF)
+		}
 	}
 	if req.RequestLine.HTTPVersion == "" ||
 		req.RequestLine.Method == "" ||
 		req.RequestLine.RequestTarget == "" {
 		return nil, fmt.Errorf("unknown error while parsing request line: line contains null values: %+v", req.RequestLine)
 	}
-	if len(req.Body) < req.reportedConentLen {
-		return req, fmt.Errorf("body is shorter than content-length, actual: %d, reported: %d", len(req.Body), req.reportedConentLen)
+	if err := req.validateHost(); err != nil {
+		return nil, err
+	}
+	if err := req.setupBody(buffer[:currReadIdx], reader); err != nil {
+		return nil, err
 	}
 	return req, nil
 }
 
+// Cookies parses the cookies sent with the request
+func (r *Request) Cookies() []*cookie.Cookie {
+	header, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return []*cookie.Cookie{}
+	}
+	return cookie.Parse(header)
+}
+
+// Cookie returns the first cookie with the given name
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// validateHost checks for the single Host header HTTP/1.1 requires
+func (r *Request) validateHost() error {
+	host, ok := r.Headers.Get("Host")
+	if !ok {
+		return ErrMissingHost
+	}
+	if strings.ContainsAny(host, " \t,/@?#") {
+		return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+	}
+	return nil
+}
+
 func (r *Request) parseLoop(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.State != requestStateDone {
@@ -101,50 +163,71 @@ func (r *Request) parseLoop(data []byte) (int, error) {
 func (r *Request) parse(data []byte) (int, error) {
 	switch r.State {
 	case requestStateInitialised:
+		if err := checkLineEndings(data); err != nil {
+			return 0, err
+		}
 		req, n, err := parseRequestLine(data)
 		if err != nil {
 			return 0, err
 		}
+		// Without a CRLF yet everything buffered belongs to the request line
+		lineLen := n - 2
+		if n == 0 {
+			lineLen = len(data) - 1
+		}
+		if lineLen > r.limits.MaxRequestLineSize {
+			return 0, fmt.Errorf("%w: over %d bytes", ErrRequestLineTooLong, r.limits.MaxRequestLineSize)
+		}
 		if n == 0 {
 			return 0, nil
 		}
+		target, err := ParseTarget(req.Method, req.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = *req
+		r.Target = target
 		r.State = requestParsingHeaders
 		return n, nil
 	case requestParsingHeaders:
+		if err := checkLineEndings(data); err != nil {
+			return 0, err
+		}
+		if len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+			return 0, ErrObsFold
+		}
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
 			return n, err
 		}
-		if n == 0 {
-			return 0, nil
+		if n == 0 && r.headerBytes+len(data) > r.limits.MaxHeaderBytes {
+			return 0, fmt.Errorf("%w: over %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
 		}
-		if done {
-			r.State = requestParsingBody
+		if len(r.Headers.Values("Host")) > 1 {
+			return 0, ErrDuplicateHost
 		}
-		return n, nil
-	case requestParsingBody:
-		contentLen, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			r.State = requestStateDone
-			r.reportedConentLen = 0
+		if n == 0 {
 			return 0, nil
 		}
-		lenInt, err := strconv.Atoi(string(contentLen))
-		if err != nil {
-			return 0, fmt.Errorf("malformed content length: %s", contentLen)
+		r.headerBytes += n
+		if n > 2 {
+			r.headerCount++
+		}
+		if r.headerBytes > r.limits.MaxHeaderBytes {
+			return 0, fmt.Errorf("%w: over %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
 		}
-		r.reportedConentLen = lenInt
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > lenInt {
-			return len(r.Body), fmt.Errorf("actual body length is longer than reported, actual: %d, reported: %d", len(r.Body), r.reportedConentLen)
+		if r.headerCount > r.limits.MaxHeaderCount {
+			return 0, fmt.Errorf("%w: over %d fields", ErrHeadersTooLarge, r.limits.MaxHeaderCount)
 		}
-		if len(r.Body) == lenInt {
+		if done {
 			r.State = requestStateDone
-			return len(data), nil
+			// Parse can report done by peeking at the empty line that ends
+			// the headers, in which case that line still needs consuming
+			if n > 2 {
+				n += 2
+			}
 		}
-		r.totalBodyParsed += len(data)
-		return len(data), nil
+		return n, nil
 	default:
 		return 0, fmt.Errorf("error: trying to read data in an invalid state")
 	}
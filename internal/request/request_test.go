@@ -1,14 +1,33 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
 
+	"github.com/2bitburrito/http-implementation/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// readBody reads the whole of a request body
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	b, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+// headerValue returns the combined value of a field, or "" if it wasn't sent
+func headerValue(h *headers.Headers, name string) string {
+	v, _ := h.Get(name)
+	return v
+}
+
 type chunkReader struct {
 	data            string
 	numBytesPerRead int
@@ -43,9 +62,9 @@ func TestRequestLineParse(t *testing.T) {
 	assert.Equal(t, "GET", r.RequestLine.Method)
 	assert.Equal(t, "/", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HTTPVersion)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", headerValue(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", headerValue(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", headerValue(r.Headers, "accept"))
 
 	// Test: Good GET Request line with path
 	reader = &chunkReader{
@@ -80,8 +99,8 @@ func TestRequestLineParse(t *testing.T) {
 		"Accept: */*\r\n\r\n"))
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	require.Equal(t, "localhost:42069", r.Headers["host"])
-	require.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
+	require.Equal(t, "localhost:42069", headerValue(r.Headers, "host"))
+	require.Equal(t, "curl/7.81.0", headerValue(r.Headers, "user-agent"))
 	assert.Equal(t, "GET", r.RequestLine.Method)
 	assert.Equal(t, "/", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HTTPVersion)
@@ -137,15 +156,23 @@ func TestRequestLineParse(t *testing.T) {
 	require.Error(t, err, r)
 
 	// Test: Good Path with "*"
-	r, err = RequestFromReader(strings.NewReader("POST * HTTP/1.1\r\n" +
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\n" +
 		"Host: localhost:42069\r\n" +
 		"User-Agent: curl/7.81.0\r\n" +
 		"Accept: */*\r\n\r\n"))
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.Equal(t, "OPTIONS", r.RequestLine.Method)
 	assert.Equal(t, "*", r.RequestLine.RequestTarget)
 	assert.Equal(t, "1.1", r.RequestLine.HTTPVersion)
+	assert.Equal(t, AsteriskForm, r.Target.Form)
+
+	// Test: "*" is only for OPTIONS
+	_, err = RequestFromReader(strings.NewReader("POST * HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"User-Agent: curl/7.81.0\r\n" +
+		"Accept: */*\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidTarget)
 
 	// Test: Invalid version in Request line
 	_, err = RequestFromReader(strings.NewReader("OPTIONS /forrest/gump TCP/1.1\r\n" +
@@ -155,6 +182,96 @@ func TestRequestLineParse(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestTargetParse(t *testing.T) {
+	// Test: Origin-form with query
+	target, err := ParseTarget("GET", "/search/caf%C3%A9?q=hello+world&tag=a&tag=b%26c&empty")
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, target.Form)
+	assert.Equal(t, "/search/café", target.Path)
+	assert.Equal(t, "/search/caf%C3%A9", target.RawPath)
+	assert.Equal(t, "q=hello+world&tag=a&tag=b%26c&empty", target.RawQuery)
+	assert.Equal(t, "hello world", target.Query.Get("q"))
+	assert.Equal(t, []string{"a", "b&c"}, target.Query["tag"])
+	assert.True(t, target.Query.Has("empty"))
+	assert.False(t, target.Query.Has("missing"))
+
+	// Test: Escaped path keeps decoded delimiters out of a URL
+	target, err = ParseTarget("GET", "/x/../anything%3Fx=1%23/caf%C3%A9%25?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, "/anything?x=1#/café%", target.Path)
+	assert.Equal(t, "/anything%3Fx=1%23/caf%C3%A9%25", target.EscapedPath())
+
+	// Test: Dot-segments are removed
+	target, err = ParseTarget("GET", "/a/./b/../c/")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/c/", target.Path)
+	target, err = ParseTarget("GET", "/a/b/..")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/", target.Path)
+
+	// Test: Traversal above the root
+	_, err = ParseTarget("GET", "/../etc/passwd")
+	require.ErrorIs(t, err, ErrPathTraversal)
+	_, err = ParseTarget("GET", "/static/%2e%2e/%2E%2E/etc/passwd")
+	require.ErrorIs(t, err, ErrPathTraversal)
+
+	// Test: Encoded separators inside a segment
+	for _, raw := range []string{
+		"/static/%2e%2e%2f%2e%2e%2fetc/passwd",
+		"/a/..%2f..%2fetc",
+		"/a/..%2F..%2Fetc",
+		"/a/..%5c..%5cetc",
+		"/files/a%2fb",
+	} {
+		_, err = ParseTarget("GET", raw)
+		require.ErrorIs(t, err, ErrPathTraversal, raw)
+	}
+
+	// Test: Bad percent-encoding
+	_, err = ParseTarget("GET", "/bad%zzpath")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+	_, err = ParseTarget("GET", "/bad%4")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+	_, err = ParseTarget("GET", "/ok?q=%")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+	_, err = ParseTarget("GET", "/nul%00byte")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Absolute-form
+	target, err = ParseTarget("GET", "http://example.com:8080/path?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "example.com:8080", target.Host)
+	assert.Equal(t, "/path", target.Path)
+	assert.Equal(t, "1", target.Query.Get("x"))
+	target, err = ParseTarget("GET", "https://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "/", target.Path)
+	_, err = ParseTarget("GET", "ftp://example.com/")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Authority-form
+	target, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, target.Form)
+	assert.Equal(t, "example.com:443", target.Host)
+	_, err = ParseTarget("CONNECT", "/path")
+	require.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Asterisk-form
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, target.Form)
+
+	// Test: Target on a parsed request
+	r, err := RequestFromReader(strings.NewReader("GET /coffee/../tea?sugar=2 HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/tea", r.Target.Path)
+	assert.Equal(t, "2", r.Target.Query.Get("sugar"))
+}
+
 func TestHeadersParse(t *testing.T) {
 	// Test: Standard Headers
 	reader := &chunkReader{
@@ -167,9 +284,9 @@ func TestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", headerValue(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", headerValue(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", headerValue(r.Headers, "accept"))
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -183,16 +300,16 @@ func TestHeadersParse(t *testing.T) {
 	// Test: Empty Headers
 	reader = &chunkReader{
 		data: "GET / HTTP/1.1\r\n" +
-			"r\n",
+			"\r\n",
 		numBytesPerRead: 2,
 	}
-	r, err = RequestFromReader(reader)
-	require.NoError(t, err)
-	require.NotNil(t, r)
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrMissingHost)
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
 		data: "GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
 			"Captain: I am the captain now\r\n" +
 			"Captain: No I am the captain now\r\n" +
 			"Tom: Hanks\r\n\r\n",
@@ -201,8 +318,60 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "I am the captain now, No I am the captain now", r.Headers["captain"])
-	assert.Equal(t, "Hanks", r.Headers["tom"])
+	assert.Equal(t, "I am the captain now, No I am the captain now", headerValue(r.Headers, "captain"))
+	assert.Equal(t, "Hanks", headerValue(r.Headers, "tom"))
+}
+
+func TestHostValidation(t *testing.T) {
+	// Test: Missing Host
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Accept: */*\r\n\r\n"))
+	require.ErrorIs(t, err, ErrMissingHost)
+
+	// Test: Duplicate Host
+	reader := &chunkReader{
+		data: "GET / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Accept: */*\r\n" +
+			"Host: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrDuplicateHost)
+
+	// Test: Invalid Host
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: user@localhost\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidHost)
+
+	// Test: Empty Host is allowed
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host:\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", headerValue(r.Headers, "host"))
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Cookies across multiple headers
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Cookie: session=abc123; theme=dark\r\n" +
+		"Cookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "lang", cookies[2].Name)
+	c, ok := r.Cookie("theme")
+	require.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No cookies
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
 }
 
 func TestBodyParse(t *testing.T) {
@@ -218,7 +387,8 @@ func TestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, int64(13), r.ContentLength)
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -229,9 +399,75 @@ func TestBodyParse(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTruncated)
+
+	// Test: Body is not read past content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello, and the next request",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.Equal(t, "hello, and the next request"[5:], reader.data[reader.pos:])
+
+	// Test: Body is read on demand
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 2000\r\n" +
+			"\r\n" +
+			strings.Repeat("a", 2000),
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Less(t, reader.pos, 1024)
+	assert.Equal(t, strings.Repeat("a", 2000), readBody(t, r))
+
+	// Test: Close drains a small unread body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 2000\r\n" +
+			"\r\n" +
+			strings.Repeat("a", 2000) + "next",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	assert.Equal(t, "next", reader.data[reader.pos:])
+	_, err = r.Body.Read(make([]byte, 1))
+	require.ErrorIs(t, err, ErrBodyClosed)
+
+	// Test: Close refuses to drain a large unread body
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n", MaxDrainSize+1) +
+		"\r\n"))
+	require.NoError(t, err)
+	require.ErrorIs(t, r.Body.Close(), ErrBodyNotDrained)
+
+	// Test: Malformed content length
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: -1\r\n" +
+		"\r\n"))
 	require.Error(t, err)
 
+	// Test: Connection closed before the end of the headers
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
 	// Test: No Content-Length but Body Exists
 	reader = &chunkReader{
 		data: "POST /submit HTTP/1.1\r\n" +
@@ -243,7 +479,7 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "", readBody(t, r))
 
 	// Test: No body
 	reader = &chunkReader{
@@ -270,3 +506,506 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 }
+
+func TestBodyDecoding(t *testing.T) {
+	payload := `{"type": "express", "size": "massive"}`
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(payload))
+	gz.Close()
+
+	// Test: Gzip body
+	reader := &chunkReader{
+		data: "POST /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Encoding: gzip\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n", gzipped.Len()) +
+			"\r\n" +
+			gzipped.String(),
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, payload, readBody(t, r))
+	_, ok := r.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+	_, ok = r.Headers.Get("Content-Length")
+	assert.False(t, ok)
+
+	// Test: Deflate body
+	deflated := bytes.Buffer{}
+	zw := zlib.NewWriter(&deflated)
+	zw.Write([]byte(payload))
+	zw.Close()
+	r, err = RequestFromReader(strings.NewReader("POST /coffee HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: deflate\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n", deflated.Len()) +
+		"\r\n" +
+		deflated.String()))
+	require.NoError(t, err)
+	assert.Equal(t, payload, readBody(t, r))
+
+	// Test: Nothing is read from the body until the handler reads it
+	r, err = RequestFromReader(strings.NewReader("POST /coffee HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: gzip\r\n" +
+		"Content-Length: 8\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTruncated)
+
+	// Test: Bad gzip header is reported to the handler
+	r, err = RequestFromReader(strings.NewReader("POST /coffee HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: gzip\r\n" +
+		"Content-Length: 19\r\n" +
+		"\r\n" +
+		"definitely not gzip"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, gzip.ErrHeader)
+	require.NoError(t, r.Body.Close())
+
+	// Test: Unknown coding
+	_, err = RequestFromReader(strings.NewReader("POST /coffee HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: br\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"))
+	require.ErrorIs(t, err, ErrUnsupportedEncoding)
+
+	// Test: Decoded body over the limit
+	limits := Limits{MaxDecodedBodySize: 1 << 10}
+	bomb := bytes.Buffer{}
+	gz = gzip.NewWriter(&bomb)
+	gz.Write(make([]byte, limits.MaxDecodedBodySize+1))
+	gz.Close()
+	r, err = RequestFromReaderLimited(strings.NewReader("POST /coffee HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Content-Encoding: gzip\r\n"+
+		fmt.Sprintf("Content-Length: %d\r\n", bomb.Len())+
+		"\r\n"+
+		bomb.String()), limits)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.Equal(t, limits.MaxDecodedBodySize, int64(len(decoded)))
+
+	// Test: Decoded body within the default limit
+	bomb.Reset()
+	gz = gzip.NewWriter(&bomb)
+	gz.Write(make([]byte, 2<<10))
+	gz.Close()
+	r, err = RequestFromReader(strings.NewReader("POST /coffee HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: gzip\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n", bomb.Len()) +
+		"\r\n" +
+		bomb.String()))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Len(t, decoded, 2<<10)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type order struct {
+		Drink string `json:"drink"`
+		Shots int    `json:"shots"`
+	}
+	jsonRequest := func(contentType, body string) *Request {
+		raw := "POST /orders HTTP/1.1\r\nHost: localhost:42069\r\n"
+		if contentType != "" {
+			raw += "Content-Type: " + contentType + "\r\n"
+		}
+		raw += fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Valid body
+	o := order{}
+	err := jsonRequest("application/json; charset=utf-8", `{"drink":"flat white","shots":2}`).DecodeJSON(&o, 0)
+	require.NoError(t, err)
+	assert.Equal(t, order{Drink: "flat white", Shots: 2}, o)
+
+	// Test: +json media types
+	err = jsonRequest("application/vnd.orders+json", `{"drink":"mocha"}`).DecodeJSON(&o, 0)
+	require.NoError(t, err)
+
+	// Test: Wrong or missing content type
+	err = jsonRequest("text/plain", `{"drink":"mocha"}`).DecodeJSON(&o, 0)
+	require.ErrorIs(t, err, ErrUnsupportedMediaType)
+	err = jsonRequest("", `{"drink":"mocha"}`).DecodeJSON(&o, 0)
+	require.ErrorIs(t, err, ErrUnsupportedMediaType)
+
+	// Test: Unknown fields
+	err = jsonRequest("application/json", `{"drink":"mocha","sugar":3}`).DecodeJSON(&o, 0)
+	require.ErrorIs(t, err, ErrInvalidJSON)
+
+	// Test: Too large
+	err = jsonRequest("application/json", `{"drink":"mocha"}`).DecodeJSON(&o, 8)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Empty, malformed and trailing data
+	err = jsonRequest("application/json", "").DecodeJSON(&o, 0)
+	require.ErrorIs(t, err, ErrInvalidJSON)
+	err = jsonRequest("application/json", `{"drink":`).DecodeJSON(&o, 0)
+	require.ErrorIs(t, err, ErrInvalidJSON)
+	err = jsonRequest("application/json", `{"drink":"mocha"} {"drink":"latte"}`).DecodeJSON(&o, 0)
+	require.ErrorIs(t, err, ErrInvalidJSON)
+}
+
+func TestChunkedBody(t *testing.T) {
+	chunkedRequest := func(body string) *chunkReader {
+		return &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				body,
+			numBytesPerRead: 3,
+		}
+	}
+
+	// Test: Chunks, extensions and trailers
+	r, err := RequestFromReader(chunkedRequest("5\r\nhello\r\n" +
+		"7;name=value;flag\r\n, world\r\n" +
+		"A \t; spaced\r\n from afar\r\n" +
+		"0\r\n" +
+		"Checksum: abc123\r\n" +
+		"Expires: never\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), r.ContentLength)
+	assert.Empty(t, r.Trailers)
+	assert.Equal(t, "hello, world from afar", readBody(t, r))
+	assert.Equal(t, "abc123", headerValue(r.Trailers, "checksum"))
+	assert.Equal(t, "never", headerValue(r.Trailers, "expires"))
+	_, ok := r.Headers.Get("Checksum")
+	assert.False(t, ok)
+
+	// Test: Empty body
+	r, err = RequestFromReader(chunkedRequest("0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", readBody(t, r))
+
+	// Test: Gzipped chunked body
+	payload := `{"type": "express", "size": "massive"}`
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(payload))
+	gz.Close()
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Content-Encoding: gzip\r\n" +
+		"\r\n" +
+		fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", gzipped.Len(), gzipped.String())))
+	require.NoError(t, err)
+	assert.Equal(t, payload, readBody(t, r))
+
+	// Test: Malformed chunks
+	for _, body := range []string{
+		"zz\r\nhello\r\n0\r\n\r\n",
+		"+5\r\nhello\r\n0\r\n\r\n",
+		"\r\nhello\r\n0\r\n\r\n",
+		"3\r\nhello\r\n0\r\n\r\n",
+		"5\nhello\r\n0\r\n\r\n",
+		"5\r\nhello\r\n0\r\nBad Trailer\r\n\r\n",
+		"5\r\nhello\r\n",
+		"5\r\nhel",
+	} {
+		r, err = RequestFromReader(chunkedRequest(body))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r.Body)
+		require.ErrorIs(t, err, ErrMalformedChunk, body)
+	}
+
+	// Test: Chunk line too long
+	r, err = RequestFromReader(chunkedRequest("5;" + strings.Repeat("x", MaxChunkLineSize) + "\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrChunkLineTooLong)
+
+	// Test: Body over the limit
+	r, err = RequestFromReader(chunkedRequest(fmt.Sprintf("%x\r\n", DefaultLimits.MaxBodySize+1)))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Close drains the rest
+	reader := chunkedRequest("5\r\nhello\r\n5\r\nworld\r\n0\r\nDone: yes\r\n\r\n")
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	assert.Equal(t, "yes", headerValue(r.Trailers, "done"))
+
+	// Test: Other transfer codings
+	_, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: gzip, chunked\r\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxRequestLineSize: 32, MaxHeaderBytes: 64, MaxHeaderCount: 3, MaxBodySize: 10}
+
+	// Test: Within the limits
+	r, err := RequestFromReaderLimited(strings.NewReader("GET /coffee HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Content-Length: 10\r\n"+
+		"\r\n"+
+		"0123456789"), limits)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", readBody(t, r))
+
+	// Test: Request line too long, refused before its end arrives
+	reader := &chunkReader{
+		data:            "GET /" + strings.Repeat("a", 4096) + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReaderLimited(reader, limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+	assert.Less(t, reader.pos, 64)
+
+	// Test: Header section too large, refused before its end arrives
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Big: " + strings.Repeat("b", 4096) + "\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	_, err = RequestFromReaderLimited(reader, limits)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+	assert.Less(t, reader.pos, 128)
+
+	// Test: Too many header fields
+	_, err = RequestFromReaderLimited(strings.NewReader("GET / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"A1: 1\r\n"+
+		"A2: 2\r\n"+
+		"A3: 3\r\n"+
+		"\r\n"), limits)
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length over the limit is refused before the body is read
+	_, err = RequestFromReaderLimited(strings.NewReader("POST / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"+
+		"01234567890"), limits)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the limit
+	r, err = RequestFromReaderLimited(strings.NewReader("POST / HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"6\r\n012345\r\n6\r\n678901\r\n0\r\n\r\n"), limits)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Zero fields use the defaults
+	r, err = RequestFromReaderLimited(strings.NewReader("GET /"+strings.Repeat("a", 4096)+" HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n\r\n"), Limits{})
+	require.NoError(t, err)
+
+	// Test: Lowering the body limit
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 10\r\n" +
+		"\r\n" +
+		"0123456789"))
+	require.NoError(t, err)
+	require.ErrorIs(t, r.LimitBody(5), ErrBodyTooLarge)
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"a\r\n0123456789\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.LimitBody(5))
+	b, err := io.ReadAll(r.Body)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.Equal(t, "01234", string(b))
+}
+
+func TestSmuggling(t *testing.T) {
+	// Test: Content-Length and Transfer-Encoding together
+	_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 100\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"2\r\nhi\r\n0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrConflictingFraming)
+
+	// Test: Repeated Content-Length, whether or not the values agree
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"Content-Length: 7\r\n" +
+		"\r\n" +
+		"hello12"))
+	require.ErrorIs(t, err, ErrDuplicateContentLength)
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"))
+	require.ErrorIs(t, err, ErrDuplicateContentLength)
+
+	// Test: Content-Length that isn't just digits
+	for _, cl := range []string{"+5", "-5", "0x5", "5 5", "99999999999999999999"} {
+		_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: " + cl + "\r\n" +
+			"\r\n" +
+			"hello"))
+		require.ErrorIs(t, err, ErrInvalidContentLength, cl)
+	}
+
+	// Test: Bare LF
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\n" +
+		"Host: localhost:42069\r\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, ErrBareLF)
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, ErrBareLF)
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"\n"))
+	require.ErrorIs(t, err, ErrBareLF)
+
+	// Test: Bare CR, even when it arrives split across reads
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Evil: a\rContent-Length: 5\r\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, ErrBareCR)
+	_, err = RequestFromReader(&chunkReader{
+		data:            "GET /a\rb HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1,
+	})
+	require.ErrorIs(t, err, ErrBareCR)
+
+	// Test: Obsolete line folding
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Folded: first\r\n" +
+		" second\r\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, ErrObsFold)
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"\tHost: localhost:42069\r\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, ErrObsFold)
+
+	// Test: Whitespace between field name and colon
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding : chunked\r\n" +
+		"\r\n"))
+	require.ErrorIs(t, err, headers.ErrWhitespaceBeforeColon)
+
+	// Test: Invalid chunk sizes and line endings in chunked bodies
+	for _, body := range []string{
+		"zz\r\nhello\r\n0\r\n\r\n",
+		"+5\r\nhello\r\n0\r\n\r\n",
+		"-5\r\nhello\r\n0\r\n\r\n",
+		"0x5\r\nhello\r\n0\r\n\r\n",
+		"\r\nhello\r\n0\r\n\r\n",
+		"fffffffffffffffff\r\nhello\r\n0\r\n\r\n",
+	} {
+		r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			body))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r.Body)
+		require.ErrorIs(t, err, ErrInvalidChunkSize, body)
+	}
+	for body, want := range map[string]error{
+		"5\nhello\r\n0\r\n\r\n":     ErrBareLF,
+		"5\r\r\nhello\r\n0\r\n\r\n": ErrBareCR,
+		"5\r\nhello\n0\r\n\r\n":     ErrBareLF,
+	} {
+		r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			body))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r.Body)
+		require.ErrorIs(t, err, want, body)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	// Test: Absolute URL sent in origin-form with its Host
+	req, err := NewRequest("GET", "http://example.com:8080/a%20b?x=1", nil)
+	require.NoError(t, err)
+	req.Headers.Set("Accept", "text/plain")
+	var buf bytes.Buffer
+	require.NoError(t, req.Write(&buf))
+	assert.Equal(t, "GET /a%20b?x=1 HTTP/1.1\r\nHost: example.com:8080\r\nAccept: text/plain\r\n\r\n", buf.String())
+
+	// Test: Body with a known length reads back the same
+	req, err = NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Headers.Set("Content-Length", "99")
+	buf.Reset()
+	require.NoError(t, req.Write(&buf))
+	r, err := RequestFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.Target.Path)
+	assert.Equal(t, int64(5), r.ContentLength)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	// Test: Empty POST still sends a Content-Length
+	req, err = NewRequest("POST", "http://example.com/", nil)
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, req.Write(&buf))
+	assert.Contains(t, buf.String(), "Content-Length: 0\r\n")
+
+	// Test: Body of unknown length is chunked, with trailers
+	req, err = NewRequest("PUT", "http://example.com/upload", io.MultiReader(strings.NewReader("hel"), strings.NewReader("lo")))
+	require.NoError(t, err)
+	req.Trailers.Set("X-Checksum", "abc")
+	buf.Reset()
+	require.NoError(t, req.Write(&buf))
+	r, err = RequestFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), r.ContentLength)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.Equal(t, "abc", headerValue(r.Trailers, "X-Checksum"))
+
+	// Test: Body shorter than its Content-Length
+	req, err = NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.ContentLength = 10
+	assert.ErrorIs(t, req.Write(io.Discard), ErrBodyTruncated)
+
+	// Test: Invalid requests
+	_, err = NewRequest("GE T", "http://example.com/", nil)
+	assert.ErrorIs(t, err, ErrInvalidMethod)
+	_, err = NewRequest("GET", "ftp://example.com/", nil)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+	req, err = NewRequest("GET", "/path", nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, req.Write(io.Discard), ErrMissingHost)
+	req.Headers.Set("Host", "example.com")
+	req.Headers.Set("X-Evil", "a\r\nInjected: yes")
+	assert.ErrorIs(t, req.Write(io.Discard), headers.ErrInvalidFieldValue)
+}
@@ -0,0 +1,61 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// These are all inputs that different implementations could frame
+// differently, letting a request be smuggled past a proxy in front of us
+// (RFC 9112 sections 2.2, 5.2, 6.3 and 11.2)
+var (
+	ErrConflictingFraming     = errors.New("both content-length and transfer-encoding sent")
+	ErrDuplicateContentLength = errors.New("content-length sent more than once")
+	ErrInvalidContentLength   = errors.New("invalid content-length")
+	ErrBareLF                 = errors.New("line ending in bare LF")
+	ErrBareCR                 = errors.New("bare CR in line")
+	ErrObsFold                = errors.New("obsolete line folding")
+	ErrInvalidChunkSize       = errors.New("invalid chunk size")
+)
+
+// checkLineEndings looks at the first line of data, which may not have
+// arrived in full yet, making sure it only ends in CRLF and holds no other CR
+func checkLineEndings(data []byte) error {
+	end := bytes.IndexByte(data, '\n')
+	if end == -1 {
+		// A trailing CR may be the start of a CRLF still to come
+		if i := bytes.IndexByte(data, '\r'); i != -1 && i != len(data)-1 {
+			return ErrBareCR
+		}
+		return nil
+	}
+	if end == 0 || data[end-1] != '\r' {
+		return ErrBareLF
+	}
+	if bytes.IndexByte(data[:end-1], '\r') != -1 {
+		return ErrBareCR
+	}
+	return nil
+}
+
+// checkFraming makes sure the body's length can only be read one way
+func (r *Request) checkFraming() error {
+	_, hasTE := r.Headers.Get("Transfer-Encoding")
+	contentLen, hasCL := r.Headers.Get("Content-Length")
+	if hasTE && hasCL {
+		return ErrConflictingFraming
+	}
+	if !hasCL {
+		return nil
+	}
+	// Get joins repeated fields into a list
+	if strings.Contains(contentLen, ",") {
+		return fmt.Errorf("%w: %q", ErrDuplicateContentLength, contentLen)
+	}
+	if !isDigits(contentLen) {
+		return fmt.Errorf("%w: %q", ErrInvalidContentLength, contentLen)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+type TargetForm int
+
+const (
+	OriginForm TargetForm = iota
+	AbsoluteForm
+	AuthorityForm
+	AsteriskForm
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid request target")
+	ErrPathTraversal = errors.New("request path escapes the root")
+)
+
+// Target is the parsed request-target (RFC 9112 section 3.2)
+type Target struct {
+	Form TargetForm
+	// Scheme and Host are only set for absolute-form and authority-form
+	Scheme string
+	Host   string
+	// Path is percent-decoded with dot-segments removed
+	Path string
+	// RawPath is the path exactly as it was sent
+	RawPath  string
+	RawQuery string
+	Query    Query
+}
+
+// Query holds query parameters, which may be repeated
+type Query map[string][]string
+
+// Get returns the first value for key
+func (q Query) Get(key string) string {
+	if vals := q[key]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// ParseTarget parses a request-target, using the method to tell which forms
+// are allowed
+func ParseTarget(method, target string) (Target, error) {
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] >= 0x7f {
+			return Target{}, fmt.Errorf("%w: contains byte %q", ErrInvalidTarget, target[i])
+		}
+	}
+	switch {
+	case target == "*":
+		if method != "OPTIONS" {
+			return Target{}, fmt.Errorf("%w: asterisk-form is only allowed for OPTIONS", ErrInvalidTarget)
+		}
+		return Target{Form: AsteriskForm, Query: Query{}}, nil
+	case method == "CONNECT":
+		return parseAuthorityForm(target)
+	case strings.HasPrefix(target, "/"):
+		t := Target{Form: OriginForm}
+		return t, t.parsePathAndQuery(target)
+	default:
+		return parseAbsoluteForm(target)
+	}
+}
+
+func parseAuthorityForm(target string) (Target, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" || strings.ContainsAny(host, "/?#@") || !isDigits(port) {
+		return Target{}, fmt.Errorf("%w: authority-form must be host:port, got %q", ErrInvalidTarget, target)
+	}
+	return Target{Form: AuthorityForm, Host: target, Query: Query{}}, nil
+}
+
+func parseAbsoluteForm(target string) (Target, error) {
+	scheme, rest, found := strings.Cut(target, "://")
+	scheme = strings.ToLower(scheme)
+	if !found || (scheme != "http" && scheme != "https") {
+		return Target{}, fmt.Errorf("%w: %q", ErrInvalidTarget, target)
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if host == "" || strings.ContainsAny(host, "@#") {
+		return Target{}, fmt.Errorf("%w: bad authority in %q", ErrInvalidTarget, target)
+	}
+	pathAndQuery := rest[end:]
+	if !strings.HasPrefix(pathAndQuery, "/") {
+		pathAndQuery = "/" + pathAndQuery
+	}
+	t := Target{Form: AbsoluteForm, Scheme: scheme, Host: host}
+	return t, t.parsePathAndQuery(pathAndQuery)
+}
+
+func (t *Target) parsePathAndQuery(s string) error {
+	if strings.Contains(s, "#") {
+		return fmt.Errorf("%w: fragments aren't sent in requests", ErrInvalidTarget)
+	}
+	rawPath, rawQuery, _ := strings.Cut(s, "?")
+	path, err := cleanPath(rawPath)
+	if err != nil {
+		return err
+	}
+	query, err := ParseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	t.RawPath = rawPath
+	t.Path = path
+	t.RawQuery = rawQuery
+	t.Query = query
+	return nil
+}
+
+// cleanPath decodes each segment of an absolute path and removes dot-segments
+// (RFC 3986 section 5.2.4), refusing paths that climb above the root. A
+// segment that decodes to a separator is refused as well, as it would become
+// more segments once the path is joined onto a directory.
+func cleanPath(rawPath string) (string, error) {
+	segments := strings.Split(rawPath, "/")[1:]
+	out := []string{}
+	for i, seg := range segments {
+		decoded, err := unescape(seg, false)
+		if err != nil {
+			return "", err
+		}
+		if strings.ContainsRune(decoded, 0) {
+			return "", fmt.Errorf("%w: path contains NUL", ErrInvalidTarget)
+		}
+		if strings.ContainsAny(decoded, "/\\") {
+			return "", fmt.Errorf("%w: encoded separator in %q", ErrPathTraversal, rawPath)
+		}
+		last := i == len(segments)-1
+		switch decoded {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) == 0 {
+				return "", fmt.Errorf("%w: %q", ErrPathTraversal, rawPath)
+			}
+			out = out[:len(out)-1]
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, decoded)
+		}
+	}
+	return "/" + strings.Join(out, "/"), nil
+}
+
+// EscapedPath returns Path with each segment percent-encoded again, so it can
+// be put into a URL without any of it being taken for a query or fragment
+func (t Target) EscapedPath() string {
+	segments := strings.Split(t.Path, "/")
+	for i, seg := range segments {
+		segments[i] = escapeSegment(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escapeSegment percent-encodes everything but the characters a path segment
+// can hold as they are (RFC 3986 section 3.3)
+func escapeSegment(s string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) || strings.IndexByte("!$&'()*+,;=:@", c) != -1 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte("-._~", c) != -1
+}
+
+// ParseQuery parses an application/x-www-form-urlencoded query string
+func ParseQuery(rawQuery string) (Query, error) {
+	query := Query{}
+	if rawQuery == "" {
+		return query, nil
+	}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawVal, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		val, err := unescape(rawVal, true)
+		if err != nil {
+			return nil, err
+		}
+		query[key] = append(query[key], val)
+	}
+	return query, nil
+}
+
+// unescape percent-decodes s, also turning "+" into a space in query components
+func unescape(s string, query bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", fmt.Errorf("%w: bad percent-encoding in %q", ErrInvalidTarget, s)
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && query:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,162 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+var ErrInvalidMethod = errors.New("invalid method")
+
+// NewRequest builds a request to send, such as with the client. The target is
+// normally an absolute URL, whose host is used for the Host header.
+//
+// The Content-Length is known for a *bytes.Buffer, *bytes.Reader or
+// *strings.Reader body. Other bodies are sent chunked, and a nil body sends
+// none.
+func NewRequest(method, target string, body io.Reader) (*Request, error) {
+	if !headers.ValidName(method) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMethod, method)
+	}
+	t, err := ParseTarget(method, target)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		RequestLine: RequestLine{
+			Method:        method,
+			HTTPVersion:   "1.1",
+			RequestTarget: target,
+		},
+		Target:   t,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		State:    requestStateDone,
+		Body:     NoBody,
+		limits:   DefaultLimits,
+	}
+	if t.Host != "" {
+		req.Headers.Set("Host", t.Host)
+	}
+	switch b := body.(type) {
+	case nil:
+	case *bytes.Buffer:
+		req.ContentLength = int64(b.Len())
+	case *bytes.Reader:
+		req.ContentLength = int64(b.Len())
+	case *strings.Reader:
+		req.ContentLength = int64(b.Len())
+	default:
+		req.ContentLength = -1
+	}
+	if body != nil {
+		rc, ok := body.(io.ReadCloser)
+		if !ok {
+			rc = io.NopCloser(body)
+		}
+		req.Body = rc
+	}
+	return req, nil
+}
+
+// Write sends the request line, headers and body to w. Content-Length or
+// Transfer-Encoding are set from ContentLength, replacing any already in the
+// headers, and the trailers follow a chunked body. An absolute-form target is
+// sent in origin-form as an origin server expects (RFC 9112 section 3.2.1).
+func (r *Request) Write(w io.Writer) error {
+	h := r.Headers.Clone()
+	if _, ok := h.Get("Host"); !ok {
+		return ErrMissingHost
+	}
+	h.Del("Content-Length")
+	h.Del("Transfer-Encoding")
+	switch {
+	case r.ContentLength < 0:
+		h.Set("Transfer-Encoding", "chunked")
+	case r.ContentLength > 0 || r.Body != NoBody || bodyExpected(r.RequestLine.Method):
+		h.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	}
+	if err := h.Validate(); err != nil {
+		return fmt.Errorf("couldn't write headers: %w", err)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", r.RequestLine.Method, r.requestTarget())
+	for name, value := range h.All() {
+		fmt.Fprintf(bw, "%s: %s\r\n", name, value)
+	}
+	bw.WriteString("\r\n")
+
+	var err error
+	switch {
+	case r.ContentLength < 0:
+		err = r.writeChunked(bw)
+	case r.ContentLength > 0:
+		var n int64
+		n, err = io.CopyN(bw, r.Body, r.ContentLength)
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w: %d bytes missing", ErrBodyTruncated, r.ContentLength-n)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeChunked sends the body chunked, flushing each chunk so that a body
+// being produced as it is sent isn't held up
+func (r *Request) writeChunked(bw *bufio.Writer) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(buf[:n])
+			bw.WriteString("\r\n")
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	bw.WriteString("0\r\n")
+	if err := r.Trailers.Validate(); err != nil {
+		return fmt.Errorf("couldn't write trailers: %w", err)
+	}
+	for name, value := range r.Trailers.All() {
+		fmt.Fprintf(bw, "%s: %s\r\n", name, value)
+	}
+	_, err := bw.WriteString("\r\n")
+	return err
+}
+
+// requestTarget is the target as it goes in the request line
+func (r *Request) requestTarget() string {
+	switch r.Target.Form {
+	case AbsoluteForm, OriginForm:
+		if r.Target.RawQuery != "" {
+			return r.Target.RawPath + "?" + r.Target.RawQuery
+		}
+		return r.Target.RawPath
+	default:
+		return r.RequestLine.RequestTarget
+	}
+}
+
+// bodyExpected is true for methods whose requests normally have a body, so
+// an empty one is sent with a Content-Length of 0
+func bodyExpected(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
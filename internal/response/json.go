@@ -0,0 +1,92 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+)
+
+// WriteJSON writes a complete response with v encoded as the body
+func WriteJSON(w Writer, status StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't encode json response: %w", err)
+	}
+	return writeComplete(w, status, "application/json", body)
+}
+
+func writeComplete(w Writer, status StatusCode, contentType string, body []byte) error {
+	if err := w.WriteStatusLine(status); err != nil {
+		return err
+	}
+	h := GetDefaultHeaders(len(body))
+	h.Set("Content-Type", contentType)
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
+
+// Problem is an RFC 9457 problem details object
+type Problem struct {
+	// Type is a URI identifying the kind of problem, "about:blank" if empty
+	Type     string
+	Title    string
+	Status   StatusCode
+	Detail   string
+	Instance string
+	// Extensions are extra members added alongside the standard ones
+	Extensions map[string]any
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := maps.Clone(p.Extensions)
+	if m == nil {
+		m = map[string]any{}
+	}
+	m["type"] = p.Type
+	if p.Type == "" {
+		m["type"] = "about:blank"
+	}
+	m["status"] = int(p.Status)
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// WriteProblem writes p as an application/problem+json response
+func WriteProblem(w Writer, p *Problem) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("couldn't encode problem: %w", err)
+	}
+	return writeComplete(w, p.Status, "application/problem+json", body)
+}
+
+// ProblemForError describes an error returned by Request.DecodeJSON
+func ProblemForError(err error) *Problem {
+	p := &Problem{Detail: err.Error()}
+	switch {
+	case errors.Is(err, request.ErrUnsupportedMediaType):
+		p.Status = UnsupportedMediaType
+		p.Title = "Unsupported Media Type"
+	case errors.Is(err, request.ErrBodyTooLarge):
+		p.Status = ContentTooLarge
+		p.Title = "Content Too Large"
+	default:
+		p.Status = BadRequest
+		p.Title = "Bad Request"
+	}
+	return p
+}
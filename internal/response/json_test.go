@@ -0,0 +1,72 @@
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJSON(t *testing.T) {
+	// Test: Body, length and content type
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	require.NoError(t, WriteJSON(w, OK, map[string]any{"drink": "flat white", "shots": 2}))
+	status, h, body := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "application/json", h["content-type"])
+	assert.Equal(t, fmt.Sprint(len(body)), h["content-length"])
+	assert.JSONEq(t, `{"drink":"flat white","shots":2}`, body)
+
+	// Test: Values that can't be encoded write nothing
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	require.Error(t, WriteJSON(w, OK, map[string]any{"bad": make(chan int)}))
+	assert.Empty(t, conn.buf.String())
+}
+
+func TestWriteProblem(t *testing.T) {
+	// Test: Standard members and extensions
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	require.NoError(t, WriteProblem(w, &Problem{
+		Type:       "https://example.com/probs/out-of-beans",
+		Title:      "Out of beans",
+		Status:     BadRequest,
+		Detail:     "There are no beans left for your espresso",
+		Instance:   "/orders/12",
+		Extensions: map[string]any{"restock": "tomorrow"},
+	}))
+	status, h, body := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Equal(t, "application/problem+json", h["content-type"])
+	assert.JSONEq(t, `{
+		"type": "https://example.com/probs/out-of-beans",
+		"title": "Out of beans",
+		"status": 400,
+		"detail": "There are no beans left for your espresso",
+		"instance": "/orders/12",
+		"restock": "tomorrow"
+	}`, body)
+
+	// Test: Type defaults to about:blank
+	p := &Problem{Status: ContentTooLarge}
+	b, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"about:blank","status":413}`, string(b))
+
+	// Test: Decode errors map to statuses
+	req, err := request.RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Length: 2\r\n\r\n{}"))
+	require.NoError(t, err)
+	v := map[string]any{}
+	assert.Equal(t, UnsupportedMediaType, ProblemForError(req.DecodeJSON(&v, 0)).Status)
+	assert.Equal(t, ContentTooLarge, ProblemForError(request.ErrBodyTooLarge).Status)
+	assert.Equal(t, BadRequest, ProblemForError(request.ErrInvalidJSON).Status)
+}
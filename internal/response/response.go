@@ -1,10 +1,15 @@
 package response
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/2bitburrito/http-implementation/internal/cookie"
 	"github.com/2bitburrito/http-implementation/internal/headers"
 )
 
@@ -12,60 +17,326 @@ type (
 	StatusCode int
 )
 
-type Writer struct {
-	Conn net.Conn
+// ConnWriter writes a response straight to a connection
+type ConnWriter struct {
+	Conn io.Writer
+	// BufferSize turns on buffering when above zero. The status line and
+	// headers are then held back until the body is finished or outgrows the
+	// buffer, so a Content-Length can be set for handlers that don't frame
+	// the body themselves. Bodies that outgrow it are sent chunked.
+	BufferSize int
+	state      writerState
+	status     StatusCode
+	// line is a status line held back by buffering
+	line string
+	// held are headers held back while the body is buffered
+	held        *headers.Headers
+	buf         []byte
+	filters     []BodyFilter
+	body        io.WriteCloser
+	chunked     bool
+	autoChunked bool
+	cookies     []string
+	now         func() time.Time
+	hijacked    bool
 }
 
+// DefaultBufferSize is how much of a body the server buffers by default
+const DefaultBufferSize = 4 << 10
+
+// writerState is the part of the response to be written next. A response
+// moves through them in order, only going back to the status line after an
+// interim 1xx response.
+type writerState int
+
 const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
+	stateStatusLine writerState = iota
+	stateHeaders
+	stateBody
+	stateTrailers
+	stateDone
 )
 
-func (w *Writer) WriteBody(p []byte) (int, error) {
+// ErrWriteOrder is returned when part of a response is written after a later
+// part has already gone out, such as a second status line
+var ErrWriteOrder = errors.New("response written out of order")
+
+// BodyFilter is run just before the headers are written. It may modify the
+// headers and return a writer wrapping dst which the body is then written
+// through. Filters that change the framing of the body (such as switching to
+// chunked encoding) are responsible for framing what they write to dst.
+// Returning nil leaves the body untouched.
+type BodyFilter func(status StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser
+
+// AddBodyFilter registers a filter to run when the headers are written.
+// Filters added later wrap the output of earlier ones.
+func (w *ConnWriter) AddBodyFilter(f BodyFilter) {
+	w.filters = append(w.filters, f)
+}
+
+// Started reports whether the final response has been started
+func (w *ConnWriter) Started() bool {
+	return w.state != stateStatusLine
+}
+
+// WriteBody writes part of the body. If the status line or headers haven't
+// been written yet a 200 is sent with only a Connection header, leaving the
+// body to run until the connection closes.
+func (w *ConnWriter) WriteBody(p []byte) (int, error) {
+	if err := w.startBody(false); err != nil {
+		return 0, err
+	}
+	if len(p) > 0 && !BodyAllowed(w.status) {
+		return 0, fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.status)
+	}
+	if w.held != nil {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) > w.BufferSize {
+			if err := w.flushHeld(false); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.autoChunked {
+		return w.writeChunk(p)
+	}
+	if w.body != nil {
+		return w.body.Write(p)
+	}
 	return w.Conn.Write(p)
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	d := ""
-	switch statusCode {
-	case 200:
-		d = "HTTP/1.1 200 OK\r\n"
-	case 400:
-		d = "HTTP/1.1 400 Bad Request\r\n"
-	case 500:
-		d = "HTTP/1.1 500 Internal Server Error\r\n"
+// startBody writes whatever comes before the body that the handler skipped
+func (w *ConnWriter) startBody(chunked bool) error {
+	switch w.state {
+	case stateStatusLine, stateHeaders:
+		h := headers.NewHeaders()
+		h.Set("Connection", "close")
+		if chunked {
+			h.Set("Transfer-Encoding", "chunked")
+		}
+		return w.WriteHeaders(h)
+	case stateBody:
+		return nil
 	default:
-		return fmt.Errorf("unsupported status code: %q", statusCode)
+		return fmt.Errorf("%w: body already finished", ErrWriteOrder)
 	}
-	_, err := w.Conn.Write([]byte(d))
+}
+
+// WriteStatusLine writes the status line with the registered reason phrase
+// for statusCode, which is left empty for unregistered codes
+func (w *ConnWriter) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineReason writes the status line with a custom reason phrase
+func (w *ConnWriter) WriteStatusLineReason(statusCode StatusCode, reason string) error {
+	if w.state != stateStatusLine {
+		return fmt.Errorf("%w: status line already written", ErrWriteOrder)
+	}
+	line, err := statusLine(statusCode, reason)
 	if err != nil {
 		return err
 	}
+	w.status = statusCode
+	w.state = stateHeaders
+	if w.BufferSize > 0 && !informational(statusCode) {
+		w.line = line
+		return nil
+	}
+	_, err = w.Conn.Write([]byte(line))
+	return err
+}
+
+func GetDefaultHeaders(contentLen int) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Set("Content-Length", strconv.Itoa(contentLen))
+	h.Set("Connection", "close")
+	return h
+}
+
+// AddVary adds field to the Vary header unless it is already listed
+func AddVary(h *headers.Headers, field string) {
+	vary, ok := h.Get("Vary")
+	if !ok {
+		h.Set("Vary", field)
+		return
+	}
+	for _, v := range strings.Split(vary, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.EqualFold(v, field) {
+			return
+		}
+	}
+	h.Set("Vary", vary+", "+field)
+}
+
+// SetCookie queues a Set-Cookie header to be sent with the headers. Each
+// cookie gets its own header line.
+func (w *ConnWriter) SetCookie(c *cookie.Cookie) error {
+	if w.state > stateHeaders {
+		return fmt.Errorf("%w: cookie set after the headers", ErrWriteOrder)
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c.String())
 	return nil
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
-	contentLenStr := strconv.Itoa(contentLen)
-	headers := map[string]string{
-		"content-length": contentLenStr,
-		"connection":     "close",
+// WriteHeaders writes the header section, after a 200 status line if none
+// has been written. Nothing is written if any name or value is invalid, so a
+// value taken from user input can't end the field line and inject headers of
+// its own.
+func (w *ConnWriter) WriteHeaders(h *headers.Headers) error {
+	if w.state == stateStatusLine {
+		if err := w.WriteStatusLine(OK); err != nil {
+			return err
+		}
+	}
+	if w.state != stateHeaders {
+		return fmt.Errorf("%w: headers already written", ErrWriteOrder)
+	}
+	// Without framing from the handler the body is buffered to find its length
+	if w.line != "" && BodyAllowed(w.status) &&
+		!h.Has("Content-Length") && !h.Has("Transfer-Encoding") {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("couldn't write headers: %w", err)
+		}
+		w.held = h
+		w.state = stateBody
+		return nil
 	}
-	return headers
+	return w.writeHeaders(h)
 }
 
-func (w *Writer) WriteHeaders(h headers.Headers) error {
-	for k, v := range h {
+func (w *ConnWriter) writeHeaders(h *headers.Headers) error {
+	// Interim responses go out as they are, ahead of the final response
+	interim := informational(w.status) && w.status != SwitchingProtocols
+	if !interim {
+		w.applyFilters(h)
+	}
+	if !BodyAllowed(w.status) {
+		w.body = nil
+		w.chunked = false
+		h.Del("Transfer-Encoding")
+		// A 304 may say how long the body would have been, but 1xx and 204
+		// responses must not send a length at all
+		if w.status != NotModified {
+			h.Del("Content-Length")
+		}
+	}
+	if err := h.Validate(); err != nil {
+		return fmt.Errorf("couldn't write headers: %w", err)
+	}
+	if w.line != "" {
+		if _, err := io.WriteString(w.Conn, w.line); err != nil {
+			return err
+		}
+		w.line = ""
+	}
+	for k, v := range h.All() {
 		_, err := fmt.Fprintf(w.Conn, "%s: %s\r\n", k, v)
 		if err != nil {
 			return err
 		}
 	}
-	w.Conn.Write([]byte("\r\n"))
-	return nil
+	if !interim {
+		for _, c := range w.cookies {
+			if _, err := fmt.Fprintf(w.Conn, "Set-Cookie: %s\r\n", c); err != nil {
+				return err
+			}
+		}
+	}
+	switch {
+	case interim:
+		w.state = stateStatusLine
+	case w.status == SwitchingProtocols:
+		w.state = stateDone
+	default:
+		w.state = stateBody
+	}
+	_, err := w.Conn.Write([]byte("\r\n"))
+	return err
+}
+
+func (w *ConnWriter) applyFilters(h *headers.Headers) {
+	te, _ := h.Get("Transfer-Encoding")
+	wasChunked := strings.EqualFold(te, "chunked")
+	var dst io.Writer = w.Conn
+	for _, f := range w.filters {
+		if wc := f(w.status, h, dst); wc != nil {
+			w.body = wc
+			dst = wc
+		}
+	}
+	te, _ = h.Get("Transfer-Encoding")
+	w.chunked = !wasChunked && strings.EqualFold(te, "chunked")
+}
+
+// flushHeld writes the held status line and headers, followed by whatever
+// body has been buffered. A complete body gets a Content-Length, otherwise
+// the rest of the body is sent chunked. Date and Content-Type are filled in
+// if the handler didn't set them.
+func (w *ConnWriter) flushHeld(complete bool) error {
+	h := w.held
+	w.held = nil
+	body := w.buf
+	w.buf = nil
+	if complete {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	} else {
+		h.Set("Transfer-Encoding", "chunked")
+		w.autoChunked = true
+	}
+	if !h.Has("Date") {
+		now := time.Now
+		if w.now != nil {
+			now = w.now
+		}
+		h.Set("Date", now().UTC().Format(headers.TimeFormat))
+	}
+	if !h.Has("Content-Type") && len(body) > 0 {
+		h.Set("Content-Type", DetectContentType(body))
+	}
+	if err := w.writeHeaders(h); err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	var err error
+	switch {
+	case w.autoChunked:
+		_, err = w.writeChunk(body)
+	case w.body != nil:
+		_, err = w.body.Write(body)
+	default:
+		_, err = w.Conn.Write(body)
+	}
+	return err
+}
+
+func (w *ConnWriter) WriteChunkedBody(p []byte) (int, error) {
+	if err := w.startBody(true); err != nil {
+		return 0, err
+	}
+	if !BodyAllowed(w.status) {
+		return 0, fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.status)
+	}
+	if w.held != nil {
+		if err := w.flushHeld(false); err != nil {
+			return 0, err
+		}
+	}
+	return w.writeChunk(p)
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+func (w *ConnWriter) writeChunk(p []byte) (int, error) {
+	if w.body != nil {
+		return w.body.Write(p)
+	}
 	t := 0
 	n, err := fmt.Fprintf(w.Conn, "%x\r\n", len(p))
 	if err != nil {
@@ -85,7 +356,24 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	return t, nil
 }
 
-func (w *Writer) WriteChunkedBodyDone() (int, error) {
+func (w *ConnWriter) WriteChunkedBodyDone() (int, error) {
+	if err := w.startBody(true); err != nil {
+		return 0, err
+	}
+	if !BodyAllowed(w.status) {
+		return 0, fmt.Errorf("%w: %d", ErrBodyNotAllowed, w.status)
+	}
+	if w.held != nil {
+		if err := w.flushHeld(false); err != nil {
+			return 0, err
+		}
+	}
+	if w.body != nil {
+		if err := w.body.Close(); err != nil {
+			return 0, err
+		}
+	}
+	w.state = stateTrailers
 	t, err := w.Conn.Write([]byte("0\r\n"))
 	if err != nil {
 		return 0, err
@@ -93,13 +381,126 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	return t, nil
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	for k, v := range h {
+// WriteTrailers ends a chunked body, which must already have had its last
+// chunk written
+func (w *ConnWriter) WriteTrailers(h *headers.Headers) error {
+	if w.state != stateTrailers {
+		return fmt.Errorf("%w: trailers must follow the last chunk", ErrWriteOrder)
+	}
+	if err := h.Validate(); err != nil {
+		return fmt.Errorf("couldn't write trailers: %w", err)
+	}
+	for k, v := range h.All() {
 		_, err := fmt.Fprintf(w.Conn, "%s: %s\r\n", k, v)
 		if err != nil {
 			return err
 		}
 	}
-	w.Conn.Write([]byte("\r\n"))
+	w.state = stateDone
+	_, err := w.Conn.Write([]byte("\r\n"))
+	return err
+}
+
+// Close finishes a response. A status line left without headers gets the
+// default ones, a buffered body is sent and a body written through a filter
+// is closed. If the writer or a filter switched the response to chunked
+// encoding the last chunk is written.
+func (w *ConnWriter) Close() error {
+	if w.state == stateHeaders {
+		return w.WriteHeaders(GetDefaultHeaders(0))
+	}
+	if w.held != nil {
+		if err := w.flushHeld(true); err != nil {
+			return err
+		}
+	}
+	if w.state != stateBody || (w.body == nil && !w.autoChunked) {
+		return nil
+	}
+	if !w.chunked && !w.autoChunked {
+		return w.body.Close()
+	}
+	_, err := w.WriteChunkedBodyDone()
+	return err
+}
+
+// ReadFrom writes everything from r as the body. A body that needs no
+// buffering, filtering or chunking is handed to the connection's own ReadFrom
+// where it has one, which lets the kernel copy files straight to a socket.
+func (w *ConnWriter) ReadFrom(r io.Reader) (int64, error) {
+	if err := w.startBody(false); err != nil {
+		return 0, err
+	}
+	if rf, ok := w.Conn.(io.ReaderFrom); ok && w.held == nil && w.body == nil && !w.autoChunked && BodyAllowed(w.status) {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(bodyWriter{w}, r)
+}
+
+// Flush sends whatever is being held back by buffering. A body still being
+// buffered is switched to chunked encoding, since its length isn't known yet.
+func (w *ConnWriter) Flush() error {
+	if w.held != nil {
+		if err := w.flushHeld(false); err != nil {
+			return err
+		}
+	}
+	if w.line != "" {
+		if _, err := io.WriteString(w.Conn, w.line); err != nil {
+			return err
+		}
+		w.line = ""
+	}
+	if f, ok := w.Conn.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Hijack hands the connection over to the caller, which becomes responsible
+// for closing it. Only a response that hasn't been started, or one ended with
+// a 101 Switching Protocols, can be hijacked.
+func (w *ConnWriter) Hijack() (net.Conn, error) {
+	conn, ok := w.Conn.(net.Conn)
+	if !ok {
+		return nil, ErrHijackUnsupported
+	}
+	if w.state != stateStatusLine && (w.state != stateDone || w.status != SwitchingProtocols) {
+		return nil, fmt.Errorf("%w: response already started", ErrHijackUnsupported)
+	}
+	w.hijacked = true
+	w.state = stateDone
+	return conn, nil
+}
+
+// Hijacked reports whether the connection has been handed over by Hijack
+func (w *ConnWriter) Hijacked() bool {
+	return w.hijacked
+}
+
+// ChunkedWriter frames everything written to it as a single chunk per call.
+// Closing it does not write the last chunk.
+type ChunkedWriter struct {
+	Dst io.Writer
+}
+
+func (c *ChunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.Dst, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.Dst.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := c.Dst.Write([]byte("\r\n")); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (c *ChunkedWriter) Close() error {
 	return nil
 }
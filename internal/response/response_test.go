@@ -0,0 +1,269 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufConn captures what a Writer writes
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+// splitResponse returns the status line, headers and body of a response
+func splitResponse(t *testing.T, raw string) (string, map[string]string, string) {
+	t.Helper()
+	head, body, found := strings.Cut(raw, "\r\n\r\n")
+	require.True(t, found)
+	lines := strings.Split(head, "\r\n")
+	h := map[string]string{}
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(line, ": ")
+		h[strings.ToLower(k)] = v
+	}
+	return lines[0], h, body
+}
+
+func TestWriteHeaders(t *testing.T) {
+	// Test: Fields are written in order, one line each, with canonical names
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	h := GetDefaultHeaders(0)
+	h.Add("set-cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+	h.Add("x-request-id", "42")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Connection: close\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"X-Request-Id: 42\r\n"+
+		"\r\n", conn.buf.String())
+}
+
+func TestWriteHeadersInjection(t *testing.T) {
+	// Test: A value holding CRLF is refused and no field is written
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLine(OK))
+	conn.buf.Reset()
+	h := GetDefaultHeaders(0)
+	h.Set("Location", "/a\r\nSet-Cookie: admin=1")
+	err := w.WriteHeaders(h)
+	require.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	assert.Empty(t, conn.buf.String())
+
+	// Test: Trailers are checked too
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	conn.buf.Reset()
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc\n")
+	require.ErrorIs(t, w.WriteTrailers(trailers), headers.ErrInvalidFieldValue)
+	assert.Empty(t, conn.buf.String())
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: Writing the body first sends a 200 and ends the header section
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	assert.False(t, w.Started())
+	_, err := w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	assert.True(t, w.Started())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nhi", conn.buf.String())
+
+	// Test: Nothing can go back to an earlier part
+	require.ErrorIs(t, w.WriteStatusLine(BadRequest), ErrWriteOrder)
+	require.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrWriteOrder)
+	require.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWriteOrder)
+
+	// Test: Headers without a status line default to 200
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	status, _, _ := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+
+	// Test: A chunked body started implicitly is framed as chunked
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	_, h, body := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "chunked", h["transfer-encoding"])
+	assert.Equal(t, "2\r\nhi\r\n0\r\n\r\n", body)
+	_, err = w.WriteBody([]byte("more"))
+	require.ErrorIs(t, err, ErrWriteOrder)
+
+	// Test: Close finishes a status line left without headers
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLine(NoContent))
+	require.NoError(t, w.Close())
+	status, h, _ = splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 204 No Content", status)
+	assert.Equal(t, "close", h["connection"])
+}
+
+func TestBufferedWriter(t *testing.T) {
+	date := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := func() time.Time { return date }
+
+	// Test: A body that fits the buffer gets a length, date and sniffed type
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn, BufferSize: 64, now: now}
+	_, err := w.WriteBody([]byte("<html><body>"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("hi</body></html>"))
+	require.NoError(t, err)
+	assert.Empty(t, conn.buf.String())
+	require.NoError(t, w.Close())
+	status, h, body := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "28", h["content-length"])
+	assert.Equal(t, "text/html; charset=utf-8", h["content-type"])
+	assert.Equal(t, "Wed, 01 Jan 2025 12:00:00 GMT", h["date"])
+	assert.Equal(t, "<html><body>hi</body></html>", body)
+
+	// Test: The handler's status and headers are kept
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn, BufferSize: 64, now: now}
+	require.NoError(t, w.WriteStatusLine(NotFound))
+	hdrs := headers.NewHeaders()
+	hdrs.Set("Content-Type", "text/csv")
+	require.NoError(t, w.WriteHeaders(hdrs))
+	_, err = w.WriteBody([]byte("a,b"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	status, h, body = splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 404 Not Found", status)
+	assert.Equal(t, "3", h["content-length"])
+	assert.Equal(t, "text/csv", h["content-type"])
+	assert.Equal(t, "a,b", body)
+
+	// Test: An empty body is sent with a zero length
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn, BufferSize: 64, now: now}
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	require.NoError(t, w.Close())
+	_, h, _ = splitResponse(t, conn.buf.String())
+	assert.Equal(t, "0", h["content-length"])
+	_, ok := h["content-type"]
+	assert.False(t, ok)
+
+	// Test: Outgrowing the buffer switches to chunked
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn, BufferSize: 4, now: now}
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("def"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("gh"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, h, body = splitResponse(t, conn.buf.String())
+	assert.Equal(t, "chunked", h["transfer-encoding"])
+	_, ok = h["content-length"]
+	assert.False(t, ok)
+	assert.Equal(t, "6\r\nabcdef\r\n2\r\ngh\r\n0\r\n", body)
+
+	// Test: Bodies the handler frames itself aren't buffered
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn, BufferSize: 64, now: now}
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok", conn.buf.String())
+}
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "text/html; charset=utf-8", DetectContentType([]byte("  <!doctype html><p>hi")))
+	assert.Equal(t, "text/xml; charset=utf-8", DetectContentType([]byte("<?xml version=\"1.0\"?>")))
+	assert.Equal(t, "image/png", DetectContentType([]byte("\x89PNG\r\n\x1a\n\x00\x00")))
+	assert.Equal(t, "application/pdf", DetectContentType([]byte("%PDF-1.7")))
+	assert.Equal(t, "text/plain; charset=utf-8", DetectContentType([]byte("plain old text, café")))
+	assert.Equal(t, "application/octet-stream", DetectContentType([]byte{0x00, 0x01, 0x02}))
+}
+
+// statusRecorder is the kind of wrapper middleware can now put around a writer
+type statusRecorder struct {
+	Writer
+	status StatusCode
+}
+
+func (s *statusRecorder) WriteStatusLine(code StatusCode) error {
+	s.status = code
+	return s.Writer.WriteStatusLine(code)
+}
+
+func TestWriterCapabilities(t *testing.T) {
+	// Test: Wrapped writers still write the response
+	conn := &bufConn{}
+	rec := &statusRecorder{Writer: &ConnWriter{Conn: conn}}
+	require.NoError(t, WriteJSON(rec, Created, map[string]string{"id": "1"}))
+	assert.Equal(t, Created, rec.status)
+	status, _, _ := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "HTTP/1.1 201 Created", status)
+
+	// Test: Copy uses ReadFrom and works through wrappers without it
+	conn = &bufConn{}
+	n, err := Copy(&ConnWriter{Conn: conn}, strings.NewReader("streamed"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), n)
+	assert.True(t, strings.HasSuffix(conn.buf.String(), "\r\n\r\nstreamed"))
+	conn = &bufConn{}
+	_, err = Copy(&statusRecorder{Writer: &ConnWriter{Conn: conn}}, strings.NewReader("streamed"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(conn.buf.String(), "\r\n\r\nstreamed"))
+
+	// Test: Flush sends a buffered body so far as chunks
+	conn = &bufConn{}
+	w := &ConnWriter{Conn: conn, BufferSize: 64}
+	_, err = w.WriteBody([]byte("data: 1\n\n"))
+	require.NoError(t, err)
+	assert.Empty(t, conn.buf.String())
+	require.NoError(t, w.Flush())
+	_, h, body := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "chunked", h["transfer-encoding"])
+	assert.Equal(t, "9\r\ndata: 1\n\n\r\n", body)
+
+	// Test: Only a response that hasn't started can be hijacked
+	client, server := net.Pipe()
+	defer client.Close()
+	w = &ConnWriter{Conn: server}
+	hijacked, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, hijacked)
+	assert.True(t, w.Hijacked())
+	w = &ConnWriter{Conn: server}
+	go io.Copy(io.Discard, client)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err = w.Hijack()
+	require.ErrorIs(t, err, ErrHijackUnsupported)
+
+	// Test: Writers that aren't on a connection can't be hijacked
+	w = &ConnWriter{Conn: &bytes.Buffer{}}
+	_, err = w.Hijack()
+	require.ErrorIs(t, err, ErrHijackUnsupported)
+}
@@ -0,0 +1,95 @@
+package response
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a body is looked at to guess its type
+const sniffLen = 512
+
+// htmlTags start a document that is treated as HTML, matched without regard
+// to case and followed by a space or ">"
+var htmlTags = []string{
+	"<!DOCTYPE HTML", "<HTML", "<HEAD", "<SCRIPT", "<IFRAME", "<H1", "<DIV",
+	"<FONT", "<TABLE", "<A", "<STYLE", "<TITLE", "<B", "<BODY", "<BR", "<P",
+	"<!--",
+}
+
+var signatures = []struct {
+	prefix      string
+	contentType string
+}{
+	{"%PDF-", "application/pdf"},
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"\x1f\x8b\x08", "application/gzip"},
+	{"PK\x03\x04", "application/zip"},
+	{"\x1aE\xdf\xa3", "video/webm"},
+	{"OggS\x00", "application/ogg"},
+	{"ID3", "audio/mpeg"},
+}
+
+// DetectContentType guesses the media type of a body from its first 512
+// bytes, along the lines of the WHATWG MIME Sniffing Standard. It falls back
+// to text/plain for UTF-8 text and application/octet-stream for anything else.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	trimmed := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	for _, tag := range htmlTags {
+		if hasPrefixFold(trimmed, tag) && len(trimmed) > len(tag) &&
+			(trimmed[len(tag)] == ' ' || trimmed[len(tag)] == '>') {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.contentType
+		}
+	}
+	// RIFF containers say what they hold at offset 8
+	if len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) {
+		switch string(data[8:12]) {
+		case "WEBP":
+			return "image/webp"
+		case "WAVE":
+			return "audio/wav"
+		case "AVI ":
+			return "video/avi"
+		}
+	}
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		return "video/mp4"
+	}
+	if isText(data) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+func hasPrefixFold(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && bytes.EqualFold(data[:len(prefix)], []byte(prefix))
+}
+
+// isText reports whether data looks like UTF-8 text. A rune cut off at the
+// end of the sniffed bytes doesn't count against it.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return !utf8.FullRune(data)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\x0c' && r != '\x1b' {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
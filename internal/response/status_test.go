@@ -0,0 +1,82 @@
+package response
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusLine(t *testing.T) {
+	// Test: Registered codes get their reason phrase
+	for code, reason := range map[StatusCode]string{
+		Created:            "Created",
+		MovedPermanently:   "Moved Permanently",
+		NotFound:           "Not Found",
+		TooManyRequests:    "Too Many Requests",
+		ServiceUnavailable: "Service Unavailable",
+	} {
+		conn := &bufConn{}
+		w := &ConnWriter{Conn: conn}
+		require.NoError(t, w.WriteStatusLine(code))
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, reason), conn.buf.String())
+	}
+
+	// Test: Unregistered codes keep the space before an empty reason
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLine(599))
+	assert.Equal(t, "HTTP/1.1 599 \r\n", conn.buf.String())
+
+	// Test: Custom reason phrase
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLineReason(299, "Mostly Fine"))
+	assert.Equal(t, "HTTP/1.1 299 Mostly Fine\r\n", conn.buf.String())
+
+	// Test: Codes must be three digits and reasons a single line
+	w = &ConnWriter{Conn: &bufConn{}}
+	require.ErrorIs(t, w.WriteStatusLine(99), ErrInvalidStatusCode)
+	require.ErrorIs(t, w.WriteStatusLine(1000), ErrInvalidStatusCode)
+	require.ErrorIs(t, w.WriteStatusLineReason(200, "OK\r\nX-Injected: 1"), ErrInvalidReason)
+	assert.False(t, w.Started())
+}
+
+func TestNoBodyStatuses(t *testing.T) {
+	// Test: 204 drops framing headers and refuses a body
+	conn := &bufConn{}
+	w := &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLine(NoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err := w.WriteBody([]byte("body"))
+	require.ErrorIs(t, err, ErrBodyNotAllowed)
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n", conn.buf.String())
+
+	// Test: 304 keeps the length the body would have had
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLine(NotModified))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err = w.WriteChunkedBody([]byte("body"))
+	require.ErrorIs(t, err, ErrBodyNotAllowed)
+	_, h, body := splitResponse(t, conn.buf.String())
+	assert.Equal(t, "10", h["content-length"])
+	assert.Empty(t, body)
+
+	// Test: Interim responses are followed by the final one
+	conn = &bufConn{}
+	w = &ConnWriter{Conn: conn}
+	require.NoError(t, w.WriteStatusLine(EarlyHints))
+	link := headers.NewHeaders()
+	link.Set("Link", "</style.css>; rel=preload")
+	require.NoError(t, w.WriteHeaders(link))
+	assert.False(t, w.Started())
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok", conn.buf.String())
+}
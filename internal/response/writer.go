@@ -0,0 +1,79 @@
+package response
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/2bitburrito/http-implementation/internal/cookie"
+	"github.com/2bitburrito/http-implementation/internal/headers"
+)
+
+// Writer is what handlers write a response through. ConnWriter is the
+// implementation the server hands out, and middleware can wrap it to see or
+// change what is written.
+//
+// Writers may also implement Flusher, Hijacker, TrailerWriter and
+// io.ReaderFrom. Wrappers should only claim the capabilities of the writer
+// they wrap.
+type Writer interface {
+	WriteStatusLine(statusCode StatusCode) error
+	WriteStatusLineReason(statusCode StatusCode, reason string) error
+	WriteHeaders(h *headers.Headers) error
+	WriteBody(p []byte) (int, error)
+	WriteChunkedBody(p []byte) (int, error)
+	WriteChunkedBodyDone() (int, error)
+	SetCookie(c *cookie.Cookie) error
+	AddBodyFilter(f BodyFilter)
+}
+
+// Flusher is implemented by writers that can send what they have buffered
+// so far, such as for server-sent events
+type Flusher interface {
+	Flush() error
+}
+
+// Hijacker is implemented by writers that can hand the connection over to
+// the handler, such as to speak another protocol after a 101. The server
+// leaves a hijacked connection alone once the handler returns.
+type Hijacker interface {
+	Hijack() (net.Conn, error)
+}
+
+// TrailerWriter is implemented by writers that can send trailer fields after
+// the last chunk of a chunked body
+type TrailerWriter interface {
+	WriteTrailers(h *headers.Headers) error
+}
+
+var ErrHijackUnsupported = errors.New("connection can't be hijacked")
+
+// Copy writes everything from r as the body, using w's ReadFrom when it has
+// one
+func Copy(w Writer, r io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(bodyWriter{w}, r)
+}
+
+// BodyWriter lets the body of w be used as an io.Writer
+func BodyWriter(w Writer) io.Writer {
+	return bodyWriter{w}
+}
+
+type bodyWriter struct {
+	w Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
+
+var _ interface {
+	Writer
+	Flusher
+	Hijacker
+	TrailerWriter
+	io.ReaderFrom
+} = (*ConnWriter)(nil)
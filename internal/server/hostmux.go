@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+)
+
+// HostMux dispatches requests to a Handler based on their Host header.
+// Patterns are either exact names ("example.com") or wildcard subdomains
+// ("*.example.com", which matches any depth of subdomain but not the apex).
+// Requests matching no pattern go to Default.
+type HostMux struct {
+	exact    map[string]Handler
+	wildcard map[string]Handler
+	Default  Handler
+}
+
+func NewHostMux() *HostMux {
+	return &HostMux{
+		exact:    map[string]Handler{},
+		wildcard: map[string]Handler{},
+	}
+}
+
+func (m *HostMux) Handle(pattern string, hdlr Handler) {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		m.wildcard[NormaliseHost(suffix)] = hdlr
+		return
+	}
+	m.exact[NormaliseHost(pattern)] = hdlr
+}
+
+// Dispatch is a Handler that passes the request on to the matching handler
+func (m *HostMux) Dispatch(w response.Writer, req *request.Request) {
+	host, _ := req.Headers.Get("Host")
+	hdlr := m.Match(host)
+	if hdlr == nil {
+		writeError(w, response.MisdirectedRequest)
+		return
+	}
+	hdlr(w, req)
+}
+
+// Match finds the handler for a Host header value, preferring exact names
+// then the most specific wildcard
+func (m *HostMux) Match(host string) Handler {
+	host = NormaliseHost(host)
+	if hdlr, ok := m.exact[host]; ok {
+		return hdlr
+	}
+	for suffix := host; ; {
+		_, rest, found := strings.Cut(suffix, ".")
+		if !found {
+			break
+		}
+		if hdlr, ok := m.wildcard[rest]; ok {
+			return hdlr
+		}
+		suffix = rest
+	}
+	return m.Default
+}
+
+// NormaliseHost strips any port and trailing dot from a host and lowercases it
+func NormaliseHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliseHost(t *testing.T) {
+	assert.Equal(t, "example.com", NormaliseHost("Example.COM"))
+	assert.Equal(t, "example.com", NormaliseHost("example.com:8080"))
+	assert.Equal(t, "example.com", NormaliseHost("example.com."))
+	assert.Equal(t, "::1", NormaliseHost("[::1]:42069"))
+	assert.Equal(t, "::1", NormaliseHost("[::1]"))
+}
+
+func TestHostMux(t *testing.T) {
+	matched := ""
+	named := func(name string) Handler {
+		return func(w response.Writer, req *request.Request) {
+			matched = name
+		}
+	}
+	mux := NewHostMux()
+	mux.Handle("example.com", named("apex"))
+	mux.Handle("*.example.com", named("wildcard"))
+	mux.Handle("*.api.example.com", named("api"))
+	mux.Handle("api.example.com", named("api-exact"))
+
+	match := func(host string) string {
+		matched = ""
+		if hdlr := mux.Match(host); hdlr != nil {
+			hdlr(nil, nil)
+		}
+		return matched
+	}
+
+	// Test: Exact match ignoring case and port
+	assert.Equal(t, "apex", match("EXAMPLE.com:42069"))
+
+	// Test: Wildcard subdomains
+	assert.Equal(t, "wildcard", match("www.example.com"))
+	assert.Equal(t, "wildcard", match("a.b.example.com"))
+
+	// Test: Exact beats wildcard and most specific wildcard wins
+	assert.Equal(t, "api-exact", match("api.example.com"))
+	assert.Equal(t, "api", match("v1.api.example.com"))
+
+	// Test: No match and no default
+	assert.Equal(t, "", match("example.org"))
+
+	// Test: Default
+	mux.Default = named("default")
+	assert.Equal(t, "default", match("example.org"))
+	assert.Equal(t, "default", match("notexample.com"))
+}
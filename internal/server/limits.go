@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+)
+
+// LimitBody is middleware lowering the body size limit for a route. A
+// Content-Length over the limit gets a 413 without the body being read,
+// longer chunked bodies fail with request.ErrBodyTooLarge as they are read.
+func LimitBody(n int64, next Handler) Handler {
+	return func(w response.Writer, req *request.Request) {
+		if err := req.LimitBody(n); err != nil {
+			fmt.Println("refusing request body: ", err)
+			writeError(w, response.ContentTooLarge)
+			return
+		}
+		next(w, req)
+	}
+}
@@ -2,10 +2,13 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync/atomic"
 
+	"github.com/2bitburrito/http-implementation/internal/headers"
 	"github.com/2bitburrito/http-implementation/internal/request"
 	"github.com/2bitburrito/http-implementation/internal/response"
 )
@@ -14,10 +17,21 @@ type Server struct {
 	listener net.Listener
 	isOpen   *atomic.Bool
 	Handler  Handler
+	Limits   request.Limits
+	// BufferSize is how much of each response body is buffered to work out
+	// its Content-Length. Zero uses response.DefaultBufferSize and a negative
+	// size turns buffering off.
+	BufferSize int
 }
-type Handler func(w *response.Writer, req *request.Request)
+type Handler func(w response.Writer, req *request.Request)
 
 func Serve(port int, hdlr Handler) (*Server, error) {
+	return ServeWithLimits(port, request.DefaultLimits, hdlr)
+}
+
+// ServeWithLimits is Serve with limits applied to every request. Routes can
+// lower the body limit further with LimitBody.
+func ServeWithLimits(port int, limits request.Limits, hdlr Handler) (*Server, error) {
 	isOpen := atomic.Bool{}
 	isOpen.Store(true)
 
@@ -30,6 +44,7 @@ func Serve(port int, hdlr Handler) (*Server, error) {
 		listener: listener,
 		isOpen:   &isOpen,
 		Handler:  hdlr,
+		Limits:   limits,
 	}
 	go server.listen()
 	return server, nil
@@ -45,13 +60,35 @@ func (s *Server) listen() {
 			fmt.Printf("error accepting connection: %s", err)
 			return
 		}
-		go s.handle(conn)
+		go s.ServeConn(conn)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	// Ensure we always close the request with crlf
+// ServeConn serves one request on conn and then closes it, unless the
+// handler hijacks it. The listener runs it for each connection it accepts,
+// and tests can call it with one end of a net.Pipe.
+func (s *Server) ServeConn(conn net.Conn) {
+	writer := &response.ConnWriter{
+		Conn:       conn,
+		BufferSize: s.BufferSize,
+	}
+	if writer.BufferSize == 0 {
+		writer.BufferSize = response.DefaultBufferSize
+	}
+	// Connections only carry one response, which clients must be told so
+	// they don't try to reuse them (RFC 9112 section 9.6)
+	writer.AddBodyFilter(func(status response.StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser {
+		if status != response.SwitchingProtocols {
+			h.Set("Connection", "close")
+		}
+		return nil
+	})
+	// Ensure we always close the request with crlf, unless the handler has
+	// taken the connection over
 	defer func() {
+		if writer.Hijacked() {
+			return
+		}
 		defer conn.Close()
 		_, err := fmt.Fprintf(conn, "\r\n")
 		if err != nil {
@@ -59,14 +96,54 @@ func (s *Server) handle(conn net.Conn) {
 		}
 	}()
 
-	req, err := request.RequestFromReader(conn)
+	req, err := request.RequestFromReaderLimited(conn, s.Limits)
 	if err != nil {
 		fmt.Println("error reading request: ", err)
-	}
-	writer := &response.Writer{
-		Conn: conn,
+		writeError(writer, statusForError(err))
+		return
 	}
 	s.Handler(writer, req)
+	if writer.Hijacked() {
+		return
+	}
+	if !writer.Started() {
+		fmt.Println("handler wrote no response")
+		writeError(writer, response.InternalServerError)
+	}
+	if err := writer.Close(); err != nil {
+		fmt.Println("error finishing response: ", err)
+	}
+	// Whatever the handler left unread is drained here. A body too large to
+	// drain leaves the connection unusable, and it is closed either way.
+	if err := req.Body.Close(); err != nil {
+		fmt.Println("error closing request body: ", err)
+	}
+}
+
+// statusForError maps a request parsing error onto the status sent back
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		return response.UnsupportedMediaType
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.ContentTooLarge
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.URITooLong
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.RequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return response.NotImplemented
+	default:
+		return response.BadRequest
+	}
+}
+
+func writeError(w response.Writer, status response.StatusCode) {
+	if err := w.WriteStatusLine(status); err != nil {
+		fmt.Println("error writing status line: ", err)
+		return
+	}
+	w.WriteHeaders(response.GetDefaultHeaders(0))
 }
 
 func (s *Server) Close() {
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/2bitburrito/http-implementation/internal/headers"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends raw over a pipe to s and returns the status line sent back
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	client, conn := net.Pipe()
+	go s.ServeConn(conn)
+	go func() {
+		client.Write([]byte(raw))
+	}()
+	resp, err := io.ReadAll(client)
+	require.NoError(t, err)
+	status, _, _ := strings.Cut(string(resp), "\r\n")
+	return status
+}
+
+func TestLimits(t *testing.T) {
+	ok := func(w response.Writer, req *request.Request) {
+		io.Copy(io.Discard, req.Body)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}
+	s := &Server{
+		Handler: ok,
+		Limits:  request.Limits{MaxRequestLineSize: 64, MaxHeaderBytes: 128, MaxHeaderCount: 4, MaxBodySize: 16},
+	}
+
+	// Test: Within the limits
+	status := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+
+	// Test: Request line too long
+	status = roundTrip(t, s, "GET /"+strings.Repeat("a", 100)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 414 URI Too Long", status)
+
+	// Test: Headers too large
+	status = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: "+strings.Repeat("b", 200)+"\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 431 Request Header Fields Too Large", status)
+
+	// Test: Body too large
+	status = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 17\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+
+	// Test: Route with a lower body limit
+	s.Handler = LimitBody(4, ok)
+	status = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Equal(t, "HTTP/1.1 413 Content Too Large", status)
+	status = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nhell")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+}
+
+func TestFallbackResponse(t *testing.T) {
+	// Test: A handler that writes nothing gets a 500
+	s := &Server{Handler: func(w response.Writer, req *request.Request) {}}
+	status := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+
+	// Test: A started response is left alone
+	s.Handler = func(w response.Writer, req *request.Request) {
+		w.WriteBody([]byte("hi"))
+	}
+	status = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+}
+
+func TestHijack(t *testing.T) {
+	// Test: A hijacked connection is left to the handler
+	s := &Server{Handler: func(w response.Writer, req *request.Request) {
+		conn, err := w.(response.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("raw bytes"))
+		conn.Close()
+	}}
+	client, conn := net.Pipe()
+	go s.ServeConn(conn)
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "raw bytes", string(resp))
+}
+
+func TestConnectionClose(t *testing.T) {
+	// Test: The final response says the connection closes, whatever the
+	// handler set, while interim responses are left alone
+	s := &Server{Handler: func(w response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.EarlyHints)
+		w.WriteHeaders(headers.NewHeaders())
+		h := headers.NewHeaders()
+		h.Set("Connection", "keep-alive")
+		h.Set("Content-Length", "2")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	}}
+	client, conn := net.Pipe()
+	go s.ServeConn(conn)
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	resp, err := io.ReadAll(client)
+	require.NoError(t, err)
+	interim, final, _ := strings.Cut(string(resp), "\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 103 Early Hints", interim)
+	assert.Contains(t, final, "Connection: close\r\n")
+	assert.NotContains(t, final, "keep-alive")
+}
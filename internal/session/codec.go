@@ -0,0 +1,108 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCookie = errors.New("invalid session cookie")
+
+// Codec protects the session ID held in the cookie. Codecs take a list of
+// keys: the first is used for new cookies and the rest are still accepted,
+// so keys can be rotated without logging everyone out.
+type Codec interface {
+	Encode(value string) (string, error)
+	Decode(encoded string) (string, error)
+}
+
+// SignedCodec appends an HMAC-SHA256 of the value
+type SignedCodec struct {
+	keys [][]byte
+}
+
+func NewSignedCodec(keys ...[]byte) (*SignedCodec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one signing key is needed")
+	}
+	return &SignedCodec{keys: keys}, nil
+}
+
+func (c *SignedCodec) Encode(value string) (string, error) {
+	mac := hmac.New(sha256.New, c.keys[0])
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum([]byte(value))), nil
+}
+
+func (c *SignedCodec) Decode(encoded string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(b) < sha256.Size {
+		return "", ErrInvalidCookie
+	}
+	value, sum := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	for _, key := range c.keys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(value)
+		if hmac.Equal(mac.Sum(nil), sum) {
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
+
+// EncryptedCodec seals the value with AES-GCM, so it is both hidden and
+// tamper-proof
+type EncryptedCodec struct {
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCodec takes 16, 24 or 32 byte AES keys
+func NewEncryptedCodec(keys ...[]byte) (*EncryptedCodec, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one encryption key is needed")
+	}
+	c := &EncryptedCodec{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("bad encryption key: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("bad encryption key: %w", err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+func (c *EncryptedCodec) Encode(value string) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("couldn't generate nonce: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+func (c *EncryptedCodec) Decode(encoded string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+	for _, aead := range c.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+		nonce, sealed := b[:aead.NonceSize()], b[aead.NonceSize():]
+		if value, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidCookie
+}
@@ -0,0 +1,170 @@
+// Package session keeps server-side state for clients, identified by a
+// signed or encrypted cookie
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/cookie"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+)
+
+const (
+	DefaultCookieName      = "session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 12 * time.Hour
+)
+
+type Session struct {
+	ID       string            `json:"id"`
+	Values   map[string]string `json:"values"`
+	Created  time.Time         `json:"created"`
+	LastSeen time.Time         `json:"last_seen"`
+
+	// previousID is the ID this session had before being regenerated
+	previousID string
+}
+
+type Manager struct {
+	Store Store
+	Codec Codec
+	// IdleTimeout ends sessions that haven't been used for a while
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions a fixed time after they were created,
+	// however active they are
+	AbsoluteTimeout time.Duration
+
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   cookie.SameSite
+
+	now func() time.Time
+}
+
+func NewManager(store Store, codec Codec) *Manager {
+	return &Manager{
+		Store:           store,
+		Codec:           codec,
+		IdleTimeout:     DefaultIdleTimeout,
+		AbsoluteTimeout: DefaultAbsoluteTimeout,
+		CookieName:      DefaultCookieName,
+		Path:            "/",
+		Secure:          true,
+		SameSite:        cookie.SameSiteLax,
+		now:             time.Now,
+	}
+}
+
+// Get loads the session named by the request's cookie, or starts a new one
+// if there isn't a valid, unexpired session
+func (m *Manager) Get(req *request.Request) (*Session, error) {
+	now := m.now()
+	if c, ok := req.Cookie(m.CookieName); ok {
+		id, err := m.Codec.Decode(c.Value)
+		if err == nil {
+			s, err := m.Store.Load(id)
+			if err != nil {
+				return nil, err
+			}
+			if s != nil && !m.expired(s, now) {
+				return s, nil
+			}
+			if s != nil {
+				m.Store.Delete(id)
+			}
+		}
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:       id,
+		Values:   map[string]string{},
+		Created:  now,
+		LastSeen: now,
+	}, nil
+}
+
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	return !now.Before(s.LastSeen.Add(m.IdleTimeout)) ||
+		!now.Before(s.Created.Add(m.AbsoluteTimeout))
+}
+
+// Save stores the session and queues its cookie on the response. It must be
+// called before the response headers are written.
+func (m *Manager) Save(w response.Writer, s *Session) error {
+	now := m.now()
+	s.LastSeen = now
+	expiry := now.Add(m.IdleTimeout)
+	if absolute := s.Created.Add(m.AbsoluteTimeout); absolute.Before(expiry) {
+		expiry = absolute
+	}
+	if s.previousID != "" {
+		if err := m.Store.Delete(s.previousID); err != nil {
+			return err
+		}
+		s.previousID = ""
+	}
+	if err := m.Store.Save(s, expiry); err != nil {
+		return err
+	}
+	value, err := m.Codec.Encode(s.ID)
+	if err != nil {
+		return err
+	}
+	c := m.cookie(value)
+	c.Expires = expiry
+	return w.SetCookie(c)
+}
+
+// Regenerate gives the session a new ID, keeping its values. Call it on any
+// privilege change such as logging in, so that an ID planted by an attacker
+// before then is useless (session fixation).
+func (m *Manager) Regenerate(s *Session) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.previousID == "" {
+		s.previousID = s.ID
+	}
+	s.ID = id
+	return nil
+}
+
+// Destroy removes the session and tells the client to drop its cookie
+func (m *Manager) Destroy(w response.Writer, s *Session) error {
+	if err := m.Store.Delete(s.ID); err != nil {
+		return err
+	}
+	c := m.cookie("")
+	c.MaxAge = -1
+	return w.SetCookie(c)
+}
+
+func (m *Manager) cookie(value string) *cookie.Cookie {
+	return &cookie.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("couldn't generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
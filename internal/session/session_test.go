@@ -0,0 +1,218 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/2bitburrito/http-implementation/internal/cookie"
+	"github.com/2bitburrito/http-implementation/internal/request"
+	"github.com/2bitburrito/http-implementation/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufConn captures what a response.Writer writes
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+// save runs m.Save and returns the Set-Cookie values it produced
+func save(t *testing.T, m *Manager, s *Session) *cookie.Cookie {
+	t.Helper()
+	conn := &bufConn{}
+	w := &response.ConnWriter{Conn: conn}
+	require.NoError(t, m.Save(w, s))
+	require.NoError(t, w.WriteHeaders(response.GetDefaultHeaders(0)))
+	for _, line := range strings.Split(conn.buf.String(), "\r\n") {
+		if v, ok := strings.CutPrefix(line, "Set-Cookie: "); ok {
+			c, err := cookie.ParseSetCookie(v)
+			require.NoError(t, err)
+			return c
+		}
+	}
+	t.Fatal("no Set-Cookie written")
+	return nil
+}
+
+func requestWithCookie(t *testing.T, c *cookie.Cookie) *request.Request {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+	if c != nil {
+		raw += "Cookie: " + c.Name + "=" + c.Value + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestSignedCodec(t *testing.T) {
+	oldKey := []byte("old key")
+	newKey := []byte("new key")
+	codec, err := NewSignedCodec(oldKey)
+	require.NoError(t, err)
+
+	// Test: Round trip
+	encoded, err := codec.Encode("session-id")
+	require.NoError(t, err)
+	decoded, err := codec.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "session-id", decoded)
+
+	// Test: Tampered value
+	_, err = codec.Decode(encoded[:len(encoded)-2] + "AA")
+	require.ErrorIs(t, err, ErrInvalidCookie)
+
+	// Test: Rotated keys still accept the old signature
+	rotated, err := NewSignedCodec(newKey, oldKey)
+	require.NoError(t, err)
+	decoded, err = rotated.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "session-id", decoded)
+
+	// Test: Dropped keys are no longer accepted
+	dropped, err := NewSignedCodec(newKey)
+	require.NoError(t, err)
+	_, err = dropped.Decode(encoded)
+	require.ErrorIs(t, err, ErrInvalidCookie)
+}
+
+func TestEncryptedCodec(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	codec, err := NewEncryptedCodec(oldKey)
+	require.NoError(t, err)
+
+	// Test: Round trip hides the value
+	encoded, err := codec.Encode("session-id")
+	require.NoError(t, err)
+	assert.NotContains(t, encoded, "session-id")
+	decoded, err := codec.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "session-id", decoded)
+
+	// Test: Rotation
+	rotated, err := NewEncryptedCodec(newKey, oldKey)
+	require.NoError(t, err)
+	decoded, err = rotated.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "session-id", decoded)
+
+	// Test: Garbage
+	_, err = codec.Decode("not-a-cookie")
+	require.ErrorIs(t, err, ErrInvalidCookie)
+
+	// Test: Bad key size
+	_, err = NewEncryptedCodec([]byte("short"))
+	require.Error(t, err)
+}
+
+func TestManager(t *testing.T) {
+	codec, err := NewSignedCodec([]byte("key"))
+	require.NoError(t, err)
+	store := NewMemoryStore()
+	m := NewManager(store, codec)
+	now := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	store.now = m.now
+
+	// Test: New session without a cookie
+	s, err := m.Get(requestWithCookie(t, nil))
+	require.NoError(t, err)
+	s.Values["user"] = "alice"
+	c := save(t, m, s)
+	assert.Equal(t, DefaultCookieName, c.Name)
+	assert.True(t, c.HttpOnly)
+	assert.True(t, c.Secure)
+
+	// Test: Session is loaded from the cookie
+	loaded, err := m.Get(requestWithCookie(t, c))
+	require.NoError(t, err)
+	assert.Equal(t, s.ID, loaded.ID)
+	assert.Equal(t, "alice", loaded.Values["user"])
+
+	// Test: Forged cookie starts a new session
+	forged := &cookie.Cookie{Name: DefaultCookieName, Value: s.ID}
+	loaded, err = m.Get(requestWithCookie(t, forged))
+	require.NoError(t, err)
+	assert.NotEqual(t, s.ID, loaded.ID)
+	assert.Empty(t, loaded.Values)
+
+	// Test: Regenerating drops the old ID
+	oldCookie := c
+	oldID := s.ID
+	require.NoError(t, m.Regenerate(s))
+	assert.NotEqual(t, oldID, s.ID)
+	c = save(t, m, s)
+	loaded, err = m.Get(requestWithCookie(t, oldCookie))
+	require.NoError(t, err)
+	assert.NotEqual(t, oldID, loaded.ID)
+	loaded, err = m.Get(requestWithCookie(t, c))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", loaded.Values["user"])
+
+	// Test: Idle timeout
+	now = now.Add(DefaultIdleTimeout + time.Second)
+	loaded, err = m.Get(requestWithCookie(t, c))
+	require.NoError(t, err)
+	assert.NotEqual(t, s.ID, loaded.ID)
+
+	// Test: Absolute timeout even while active
+	s, err = m.Get(requestWithCookie(t, nil))
+	require.NoError(t, err)
+	id := s.ID
+	deadline := s.Created.Add(DefaultAbsoluteTimeout)
+	for now.Add(DefaultIdleTimeout / 2).Before(deadline) {
+		c = save(t, m, s)
+		now = now.Add(DefaultIdleTimeout / 2)
+		s, err = m.Get(requestWithCookie(t, c))
+		require.NoError(t, err)
+		require.Equal(t, id, s.ID)
+	}
+	c = save(t, m, s)
+	now = deadline
+	loaded, err = m.Get(requestWithCookie(t, c))
+	require.NoError(t, err)
+	assert.NotEqual(t, id, loaded.ID)
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	s := &Session{ID: "abc123", Values: map[string]string{"user": "alice"}, Created: now, LastSeen: now}
+
+	// Test: Save and load
+	require.NoError(t, store.Save(s, now.Add(time.Minute)))
+	loaded, err := store.Load("abc123")
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	assert.Equal(t, "alice", loaded.Values["user"])
+
+	// Test: Expired
+	now = now.Add(2 * time.Minute)
+	loaded, err = store.Load("abc123")
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	// Test: Path traversal
+	loaded, err = store.Load("../../etc/passwd")
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+
+	// Test: Delete
+	require.NoError(t, store.Save(s, now.Add(time.Minute)))
+	require.NoError(t, store.Delete("abc123"))
+	loaded, err = store.Load("abc123")
+	require.NoError(t, err)
+	assert.Nil(t, loaded)
+}
@@ -0,0 +1,166 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists sessions on the server side, keyed by session ID
+type Store interface {
+	// Load returns nil with no error when the session doesn't exist or has expired
+	Load(id string) (*Session, error)
+	Save(s *Session, expiry time.Time) error
+	Delete(id string) error
+}
+
+type memoryEntry struct {
+	session *Session
+	expiry  time.Time
+}
+
+// MemoryStore keeps sessions in a map, dropping them once they expire
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+	now      func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: map[string]memoryEntry{},
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryStore) Load(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if !m.now().Before(e.expiry) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+	return e.session.clone(), nil
+}
+
+func (m *MemoryStore) Save(s *Session, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = memoryEntry{session: s.clone(), expiry: expiry}
+
+	// Sweep out anything expired while we hold the lock
+	now := m.now()
+	for id, e := range m.sessions {
+		if !now.Before(e.expiry) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileStore keeps each session as a JSON file in a directory
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+type fileSession struct {
+	Session *Session  `json:"session"`
+	Expiry  time.Time `json:"expiry"`
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("couldn't create session directory: %w", err)
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+func (f *FileStore) path(id string) (string, error) {
+	// IDs come from cookies so make sure they can't escape the directory
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid session id: %q", id)
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileStore) Load(id string) (*Session, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read session: %w", err)
+	}
+	stored := fileSession{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("couldn't decode session: %w", err)
+	}
+	if stored.Session == nil || !f.now().Before(stored.Expiry) {
+		os.Remove(path)
+		return nil, nil
+	}
+	return stored.Session, nil
+}
+
+func (f *FileStore) Save(s *Session, expiry time.Time) error {
+	path, err := f.path(s.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileSession{Session: s, Expiry: expiry})
+	if err != nil {
+		return fmt.Errorf("couldn't encode session: %w", err)
+	}
+	tmp, err := os.CreateTemp(f.dir, "tmp-*")
+	if err != nil {
+		return fmt.Errorf("couldn't save session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("couldn't save session: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't save session: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("couldn't delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Values = maps.Clone(s.Values)
+	return &c
+}